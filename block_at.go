@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/dfuse-io/dbin"
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	pbdeth "github.com/dfuse-io/doh/pb/dfuse/codecs/deth"
+	"github.com/dfuse-io/dstore"
+	"github.com/dfuse-io/kvdb"
+	"github.com/dfuse-io/kvdb/store"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+const blockAtHelp = `Finds the block produced at a time (the last one at or before it), or the
+block(s) of a number, printing their number, ID, timestamp and LIB. Blocks
+are looked up in one of:
+
+    bigtable        the [prefix]-timeline and [prefix]-blocks tables (--tables-prefix)
+                    of EOS, other protocols' tables not being keyed by block number
+    kv              the timeline and blocks of an eosdb kv store (--kv-store)
+    merged-blocks   100-blocks bundles of a merged blocks store (--merged-blocks)
+                    of any protocol, binary searched by time
+
+Block IDs only give their number for EOS blocks, other protocols' blocks
+are found by number or time.
+
+    doh block-at --time '2020-04-01 14:32' --tables-prefix eos-mainnet-v1
+    doh block-at --num 12345 --source merged-blocks --merged-blocks gs://bucket/eos-mainnet/v1
+    doh block-at --num 0000303901ab... --source kv --kv-store bigkv://project.instance/eos-mainnet-v1
+
+--kv-store and --merged-blocks default to the --profile kv and
+"merged_blocks" store.`
+
+var blockAtCmd = &cobra.Command{Use: "block-at", Short: "find a block by time or number, printing its number, ID, timestamp and LIB", Long: blockAtHelp, RunE: blockAt, Args: cobra.NoArgs}
+
+// Merged blocks files each hold this many blocks, named after the first one
+const mergedBlocksBundleSize = 100
+
+// eosdb kv layout: table prefix then block ID, its number being inverted
+// so the most recent sort first, and the timeline in deciseconds.
+const (
+	kvPrefixBlocks      = 0x01
+	kvPrefixTimelineBck = 0x81
+
+	kvMaxTimelineDeciSeconds = uint64(99999999999)
+)
+
+// blockRef is what `block-at` prints, LIB is zero when unknown
+type blockRef struct {
+	Number    uint64    `json:"number"`
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	LIB       uint64    `json:"lib,omitempty"`
+}
+
+var blockRefsOutputSpec = outputSpec{
+	defaultFormat: "table",
+	columns: []outputColumn{
+		{header: "number", path: "number"},
+		{header: "id", path: "id"},
+		{header: "timestamp", path: "timestamp"},
+		{header: "lib", path: "lib", format: func(lib gjson.Result) string {
+			if !lib.Exists() {
+				return "unknown"
+			}
+			return lib.Raw
+		}},
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(blockAtCmd)
+
+	blockAtCmd.Flags().String("time", "", "find the block at this time: "+timeExprHelp)
+	blockAtCmd.Flags().String("num", "", "find the block(s) with this number, or with this block ID")
+	blockAtCmd.Flags().String("source", "bigtable", "where blocks are looked up, one of: bigtable, kv, merged-blocks")
+	blockAtCmd.Flags().String("db", "", "bigtable project:instance or profile (defaults to the --profile one)")
+	blockAtCmd.Flags().String("tables-prefix", "", "bigtable tables name prefix, like eos-mainnet-v1 for eos-mainnet-v1-blocks")
+	blockAtCmd.Flags().String("kv-store", "", "eosdb kv store DSN (defaults to the --profile one)")
+	blockAtCmd.Flags().String("merged-blocks", "", "merged blocks store URL (defaults to the --profile merged_blocks store)")
+}
+
+func blockAt(cmd *cobra.Command, args []string) (err error) {
+	timeFlag := viper.GetString("block-at-cmd-time")
+	numFlag := viper.GetString("block-at-cmd-num")
+	if (timeFlag == "") == (numFlag == "") {
+		return fmt.Errorf("pass one of --time or --num")
+	}
+
+	// A time, or a block reference resolved by each source for its protocol
+	var at *timeExpr
+	if timeFlag != "" {
+		if at, err = parseTimeExpr(timeFlag, time.Now()); err != nil {
+			return fmt.Errorf("--time: %s", err)
+		}
+	} else {
+		blockNum, blockID, err := parseBlockRef(numFlag)
+		if err != nil {
+			return fmt.Errorf("--num: %s", err)
+		}
+		at = &timeExpr{blockNum: blockNum, blockID: blockID, isBlock: true}
+	}
+
+	ctx := context.Background()
+	var refs []*blockRef
+	switch source := viper.GetString("block-at-cmd-source"); source {
+	case "bigtable":
+		refs, err = btBlockAt(ctx, at)
+	case "kv":
+		refs, err = kvBlockAt(ctx, at)
+	case "merged-blocks":
+		refs, err = mergedBlocksAt(at)
+	default:
+		return fmt.Errorf("invalid --source %q, expected one of: bigtable, kv, merged-blocks", source)
+	}
+	if err != nil {
+		return err
+	}
+
+	if at.blockID != "" {
+		var matching []*blockRef
+		for _, ref := range refs {
+			if ref.ID == at.blockID {
+				matching = append(matching, ref)
+			}
+		}
+		refs = matching
+	}
+
+	if len(refs) == 0 {
+		return &exitError{code: exitCodeNotFound, err: fmt.Errorf("block not found")}
+	}
+
+	output, err := newRecordWriter(blockRefsOutputSpec)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := output.write(ref); err != nil {
+			return err
+		}
+	}
+	return output.close()
+}
+
+// btBlockAt resolves through the `bb:` (newest first) timeline keys, then
+// reads the blocks table, both being EOS only.
+func btBlockAt(ctx context.Context, at *timeExpr) ([]*blockRef, error) {
+	prefix := viper.GetString("block-at-cmd-tables-prefix")
+	if prefix == "" {
+		return nil, fmt.Errorf("--tables-prefix is required with the bigtable source")
+	}
+
+	project, instance, err := resolveDb(viper.GetString("block-at-cmd-db"))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	protocol, err := btTableProtocol("block-at-cmd", prefix)
+	if err != nil {
+		return nil, err
+	}
+	if protocol != pbbstream.Protocol_EOS {
+		return nil, fmt.Errorf("the bigtable source only reads EOS tables, not %s ones, use --source merged-blocks", protocol)
+	}
+
+	blocks := client.Open(prefix + "-blocks")
+	if at.isBlock {
+		if err := at.resolveBlockID(protocol); err != nil {
+			return nil, err
+		}
+		return btBlocksByNum(ctx, blocks, protocol, at.blockNum)
+	}
+
+	timelineLayout, err := parseBTKeyLayout(btKeyLayouts[pbbstream.Protocol_EOS]["timeline"][1])
+	if err != nil {
+		return nil, err
+	}
+
+	start := fmt.Sprintf("bb:%016x", math.MaxUint64-uint64(at.time.UnixNano()/int64(time.Millisecond)))
+	var blockID string
+	err = client.Open(prefix+"-timeline").ReadRows(ctx, bigtable.NewRange(start, "bb;"), func(row bigtable.Row) bool {
+		if parsed := timelineLayout.parse(row.Key()); parsed != nil {
+			blockID, _ = parsed["block_id"].(string)
+		}
+		return false
+	}, bigtable.RowFilter(bigtable.StripValueFilter()), bigtable.LimitRows(1))
+	if err != nil {
+		return nil, fmt.Errorf("reading timeline: %s", err)
+	}
+	if blockID == "" {
+		return nil, nil
+	}
+
+	blockNum, err := eosBlockIDNum(blockID)
+	if err != nil {
+		return nil, fmt.Errorf("timeline block ID %q: %s", blockID, err)
+	}
+
+	refs, err := btBlocksByNum(ctx, blocks, protocol, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if ref.ID == blockID {
+			return []*blockRef{ref}, nil
+		}
+	}
+	return nil, fmt.Errorf("timeline block %s not found in the blocks table", blockID)
+}
+
+// btBlocksByNum reads every row (one per fork) of `blockNum` in a blocks
+// table, decoding the `meta:blockheader` and `block:proto` cells. Only
+// protocols with a blocks key layout have their rows keyed by number.
+func btBlocksByNum(ctx context.Context, table *bigtable.Table, protocol pbbstream.Protocol, blockNum uint64) (out []*blockRef, err error) {
+	layouts := btKeyLayouts[protocol]["blocks"]
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("%s blocks tables are not keyed by block number, cannot find block %d", protocol, blockNum)
+	}
+
+	layout, err := parseBTKeyLayout(layouts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, _, err := layout.prefix(map[string]string{"block_num": strconv.FormatUint(blockNum, 10)})
+	if err != nil {
+		return nil, err
+	}
+
+	var innerErr error
+	err = table.ReadRows(ctx, bigtable.PrefixRange(prefix), func(row bigtable.Row) bool {
+		ref := &blockRef{Number: blockNum}
+		if parsed := layout.parse(row.Key()); parsed != nil {
+			ref.ID, _ = parsed["block_id"].(string)
+		}
+
+		hasHeader := false
+		for _, items := range row {
+			for _, item := range items {
+				msg := getProtoMap(protocol, strings.Replace(item.Column, ":", "_", 1))
+				switch msg.(type) {
+				case *pbdeos.BlockHeader, *pbdeth.BlockHeader, *pbdeos.Block:
+				default:
+					continue
+				}
+
+				if innerErr = proto.Unmarshal(item.Value, msg); innerErr != nil {
+					innerErr = fmt.Errorf("decoding block %d %s: %s", blockNum, item.Column, innerErr)
+					return false
+				}
+
+				switch m := msg.(type) {
+				case *pbdeos.BlockHeader:
+					ref.Timestamp, innerErr = ptypes.Timestamp(m.Timestamp)
+					hasHeader = true
+				case *pbdeth.BlockHeader:
+					ref.Timestamp, innerErr = ptypes.Timestamp(m.Timestamp)
+					hasHeader = true
+				case *pbdeos.Block:
+					ref.LIB = uint64(m.DposIrreversibleBlocknum)
+				}
+				if innerErr != nil {
+					innerErr = fmt.Errorf("block %d timestamp: %s", blockNum, innerErr)
+					return false
+				}
+			}
+		}
+		if !hasHeader {
+			innerErr = fmt.Errorf("block %d row %q has no %s block header column", blockNum, row.Key(), protocol)
+			return false
+		}
+
+		out = append(out, ref)
+		return true
+	}, bigtable.RowFilter(latestCellOnly))
+	if err != nil {
+		return nil, fmt.Errorf("reading block %d: %s", blockNum, err)
+	}
+	return out, innerErr
+}
+
+// kvBlockAt reads an eosdb kv store, of EOS blocks
+func kvBlockAt(ctx context.Context, at *timeExpr) ([]*blockRef, error) {
+	dsn := viper.GetString("block-at-cmd-kv-store")
+	if dsn == "" {
+		var err error
+		if dsn, err = profileKVStore(); err != nil {
+			return nil, err
+		}
+		if dsn == "" {
+			return nil, fmt.Errorf("--kv-store is required with the kv source, without a --profile kv store")
+		}
+	}
+	announceTarget(kvTarget(dsn))
+
+	kv, err := store.New(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer closeKVStore(kv)
+
+	if !at.isBlock {
+		deciSeconds := uint64(at.time.UnixNano() / int64(100*time.Millisecond))
+		start := make([]byte, 9)
+		start[0] = kvPrefixTimelineBck
+		binary.BigEndian.PutUint64(start[1:], kvMaxTimelineDeciSeconds-deciSeconds)
+
+		it := kv.Scan(ctx, start, []byte{kvPrefixTimelineBck + 1}, 1)
+		var blockID string
+		for it.Next() {
+			blockID = hex.EncodeToString(it.Item().Key[9:])
+		}
+		if err := it.Err(); err != nil {
+			return nil, fmt.Errorf("reading timeline: %s", err)
+		}
+		if blockID == "" {
+			return nil, nil
+		}
+
+		key, err := hex.DecodeString(kvdb.ReversedBlockID(blockID))
+		if err != nil {
+			return nil, fmt.Errorf("timeline block ID %q: %s", blockID, err)
+		}
+
+		value, err := kv.Get(ctx, append([]byte{kvPrefixBlocks}, key...))
+		if err != nil {
+			return nil, fmt.Errorf("reading block %s: %s", blockID, err)
+		}
+
+		ref, err := kvBlockRef(value)
+		if err != nil {
+			return nil, err
+		}
+		return []*blockRef{ref}, nil
+	}
+
+	if err := at.resolveBlockID(pbbstream.Protocol_EOS); err != nil {
+		return nil, err
+	}
+	blockNum := at.blockNum
+
+	prefix, err := hex.DecodeString(kvdb.HexRevBlockNum(uint32(blockNum)))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*blockRef
+	it := kv.Prefix(ctx, append([]byte{kvPrefixBlocks}, prefix...))
+	for it.Next() {
+		ref, err := kvBlockRef(it.Item().Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ref)
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("reading block %d: %s", blockNum, err)
+	}
+	return out, nil
+}
+
+// kvBlockRef decodes an eosdb `BlockRow`, whose field 1 is the block
+func kvBlockRef(value []byte) (*blockRef, error) {
+	buffer := proto.NewBuffer(value)
+	for {
+		tag, err := buffer.DecodeVarint()
+		if err != nil {
+			return nil, fmt.Errorf("decoding block row: no block in it")
+		}
+
+		var field []byte
+		switch tag & 0x7 {
+		case 0:
+			_, err = buffer.DecodeVarint()
+		case 1:
+			_, err = buffer.DecodeFixed64()
+		case 2:
+			field, err = buffer.DecodeRawBytes(false)
+		case 5:
+			_, err = buffer.DecodeFixed32()
+		default:
+			err = fmt.Errorf("unsupported wire type %d", tag&0x7)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding block row: %s", err)
+		}
+		if tag>>3 != 1 {
+			continue
+		}
+
+		block := &pbdeos.Block{}
+		if err := proto.Unmarshal(field, block); err != nil {
+			return nil, fmt.Errorf("decoding block row block: %s", err)
+		}
+
+		ref := &blockRef{Number: uint64(block.Number), ID: block.Id, LIB: uint64(block.DposIrreversibleBlocknum)}
+		if block.Header != nil {
+			if ref.Timestamp, err = ptypes.Timestamp(block.Header.Timestamp); err != nil {
+				return nil, err
+			}
+		}
+		return ref, nil
+	}
+}
+
+// mergedBlocksAt reads the bundle holding a block reference, or binary
+// searches the bundle holding a time on the first block time of each.
+func mergedBlocksAt(at *timeExpr) ([]*blockRef, error) {
+	storeURL := viper.GetString("block-at-cmd-merged-blocks")
+	if storeURL == "" {
+		var err error
+		if storeURL, err = profileStoreURL("merged_blocks"); err != nil {
+			return nil, err
+		}
+		if storeURL == "" {
+			return nil, fmt.Errorf("--merged-blocks is required with the merged-blocks source, without a --profile merged_blocks store")
+		}
+	}
+
+	blocksStore, err := dstore.NewDBinStore(strings.TrimSuffix(storeURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if at.isBlock {
+		if at.blockID != "" {
+			protocol, err := mergedBlocksProtocol(blocksStore)
+			if err != nil {
+				return nil, err
+			}
+			if err := at.resolveBlockID(protocol); err != nil {
+				return nil, err
+			}
+		}
+
+		blockNum := at.blockNum
+		bundle := blockNum - blockNum%mergedBlocksBundleSize
+		if found, err := blocksStore.FileExists(fmt.Sprintf("%010d", bundle)); err != nil || !found {
+			return nil, err
+		}
+
+		blocks, err := readMergedBundle(blocksStore, bundle, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var out []*blockRef
+		for _, block := range blocks {
+			if block.Number == blockNum {
+				out = append(out, block)
+			}
+		}
+		return out, nil
+	}
+
+	first, last, err := mergedBundlesBounds(blocksStore)
+	if err != nil {
+		return nil, err
+	}
+
+	// Last bundle starting at or before `at`
+	count := int((last-first)/mergedBlocksBundleSize) + 1
+	var searchErr error
+	idx := sort.Search(count, func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		blocks, err := readMergedBundle(blocksStore, first+uint64(i)*mergedBlocksBundleSize, 1)
+		if err != nil || len(blocks) == 0 {
+			searchErr = err
+			return true
+		}
+		return blocks[0].Timestamp.After(at.time)
+	}) - 1
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	if idx < 0 {
+		return nil, nil
+	}
+
+	blocks, err := readMergedBundle(blocksStore, first+uint64(idx)*mergedBlocksBundleSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *blockRef
+	for _, block := range blocks {
+		if !block.Timestamp.After(at.time) && (found == nil || block.Number >= found.Number) {
+			found = block
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	return []*blockRef{found}, nil
+}
+
+// mergedFirstBundle returns the first bundle of the store
+func mergedFirstBundle(blocksStore dstore.Store) (uint64, error) {
+	files, err := blocksStore.ListFiles("", ".tmp", 1)
+	if err != nil {
+		return 0, fmt.Errorf("listing merged blocks: %s", err)
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no merged blocks bundle found")
+	}
+
+	first, err := strconv.ParseUint(strings.TrimSuffix(files[0], ".dbin.zst"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected merged blocks file %q", files[0])
+	}
+	return first, nil
+}
+
+// mergedBlocksProtocol is the protocol of the first block of the store
+func mergedBlocksProtocol(blocksStore dstore.Store) (pbbstream.Protocol, error) {
+	first, err := mergedFirstBundle(blocksStore)
+	if err != nil {
+		return pbbstream.Protocol_UNKNOWN, err
+	}
+
+	blocks, err := readMergedBlocks(blocksStore, first, 1)
+	if err != nil {
+		return pbbstream.Protocol_UNKNOWN, err
+	}
+	if len(blocks) == 0 {
+		return pbbstream.Protocol_UNKNOWN, fmt.Errorf("merged blocks bundle %010d is empty", first)
+	}
+	return blocks[0].PayloadKind, nil
+}
+
+// mergedBundlesBounds returns the first and last bundles of the store,
+// expecting no gap between them.
+func mergedBundlesBounds(blocksStore dstore.Store) (first, last uint64, err error) {
+	if first, err = mergedFirstBundle(blocksStore); err != nil {
+		return 0, 0, err
+	}
+
+	exists := func(bundle uint64) (bool, error) {
+		return blocksStore.FileExists(fmt.Sprintf("%010d", first+bundle*mergedBlocksBundleSize))
+	}
+
+	// Double the step until a bundle is missing, then narrow down between both
+	low, high := uint64(0), uint64(1)
+	for {
+		found, err := exists(high)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !found {
+			break
+		}
+		low, high = high, high*2
+	}
+	for high-low > 1 {
+		middle := low + (high-low)/2
+		found, err := exists(middle)
+		if err != nil {
+			return 0, 0, err
+		}
+		if found {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	return first, first + low*mergedBlocksBundleSize, nil
+}
+
+// readMergedBundle reads up to `limit` blocks of a bundle, 0 for all
+func readMergedBundle(blocksStore dstore.Store, bundle uint64, limit int) (out []*blockRef, err error) {
+	blocks, err := readMergedBlocks(blocksStore, bundle, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, block := range blocks {
+		ref := &blockRef{Number: block.Number, ID: block.Id, LIB: block.LibNum}
+		if block.Timestamp != nil {
+			if ref.Timestamp, err = ptypes.Timestamp(block.Timestamp); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, ref)
+	}
+	return out, nil
+}
+
+// readMergedBlocks decodes up to `limit` blocks of a bundle, 0 for all
+func readMergedBlocks(blocksStore dstore.Store, bundle uint64, limit int) (out []*pbbstream.Block, err error) {
+	reader, err := blocksStore.OpenObject(fmt.Sprintf("%010d", bundle))
+	if err != nil {
+		return nil, fmt.Errorf("opening bundle %010d: %s", bundle, err)
+	}
+	defer reader.Close()
+
+	binReader := dbin.NewReader(reader)
+	if _, _, err := binReader.ReadHeader(); err != nil {
+		return nil, fmt.Errorf("reading bundle %010d header: %s", bundle, err)
+	}
+
+	for limit == 0 || len(out) < limit {
+		msg, err := binReader.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle %010d: %s", bundle, err)
+		}
+
+		block := &pbbstream.Block{}
+		if err := proto.Unmarshal(msg, block); err != nil {
+			return nil, fmt.Errorf("decoding bundle %010d block: %s", bundle, err)
+		}
+		out = append(out, block)
+	}
+	return out, nil
+}
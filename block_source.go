@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dfuse-io/dbin"
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/dfuse-io/dstore"
+	"github.com/golang/protobuf/proto"
+)
+
+// blockSource reads the blocks of a dbin file (zstd compressed or not) or
+// of a merged blocks store, a batch at a time.
+type blockSource struct {
+	// next returns the next blocks, io.EOF at the end
+	next  func() ([]*pbbstream.Block, error)
+	close func()
+}
+
+// newBlockSource reads a dbin file when `source` is one, and a merged
+// blocks store otherwise, skipping blocks before `startBlock`.
+func newBlockSource(source string, startBlock uint64) (*blockSource, error) {
+	var blocks *blockSource
+	var err error
+	if info, statErr := os.Stat(source); statErr == nil && info.Mode().IsRegular() {
+		blocks, err = newFileBlockSource(source)
+	} else {
+		blocks, err = newStoreBlockSource(source, startBlock)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	next := blocks.next
+	blocks.next = func() ([]*pbbstream.Block, error) {
+		for {
+			batch, err := next()
+			if err != nil {
+				return nil, err
+			}
+
+			var kept []*pbbstream.Block
+			for _, block := range batch {
+				if block.Number >= startBlock {
+					kept = append(kept, block)
+				}
+			}
+			if len(kept) != 0 {
+				return kept, nil
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func newFileBlockSource(path string) (*blockSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := maybeZstdReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	binReader := dbin.NewReader(reader)
+	if _, _, err := binReader.ReadHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading dbin header: %s", err)
+	}
+
+	return &blockSource{
+		next: func() ([]*pbbstream.Block, error) {
+			msg, err := binReader.ReadMessage()
+			if err != nil {
+				return nil, err
+			}
+
+			block := &pbbstream.Block{}
+			if err := proto.Unmarshal(msg, block); err != nil {
+				return nil, fmt.Errorf("proto unmarshal: %s", err)
+			}
+			return []*pbbstream.Block{block}, nil
+		},
+		close: func() { file.Close() },
+	}, nil
+}
+
+func newStoreBlockSource(storeURL string, startBlock uint64) (*blockSource, error) {
+	blocksStore, err := dstore.NewDBinStore(strings.TrimSuffix(storeURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := startBlock - startBlock%mergedBlocksBundleSize
+	if startBlock == 0 {
+		if bundle, _, err = mergedBundlesBounds(blocksStore); err != nil {
+			return nil, err
+		}
+	}
+
+	return &blockSource{
+		next: func() ([]*pbbstream.Block, error) {
+			found, err := blocksStore.FileExists(fmt.Sprintf("%010d", bundle))
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return nil, io.EOF
+			}
+
+			blocks, err := readMergedBlocks(blocksStore, bundle, 0)
+			bundle += mergedBlocksBundleSize
+			return blocks, err
+		},
+		close: func() {},
+	}, nil
+}
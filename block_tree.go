@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	pbdeth "github.com/dfuse-io/doh/pb/dfuse/codecs/deth"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	tspb "github.com/golang/protobuf/ptypes/timestamp"
+)
+
+// blockTreeNode is one line of a block, transaction or trace tree, as
+// browsed by `doh browse`.
+type blockTreeNode struct {
+	label    string
+	children []*blockTreeNode
+
+	expanded bool
+}
+
+func newTreeNode(format string, args ...interface{}) *blockTreeNode {
+	return &blockTreeNode{label: fmt.Sprintf(format, args...)}
+}
+
+func (n *blockTreeNode) add(children ...*blockTreeNode) *blockTreeNode {
+	for _, child := range children {
+		if child != nil {
+			n.children = append(n.children, child)
+		}
+	}
+	return n
+}
+
+// addList adds a `title (count)` child holding `items`, nothing when empty
+func (n *blockTreeNode) addList(title string, items []*blockTreeNode) *blockTreeNode {
+	if len(items) == 0 {
+		return n
+	}
+	return n.add(&blockTreeNode{label: fmt.Sprintf("%s (%d)", title, len(items)), children: items})
+}
+
+// addText adds multi-line text as a child, a line per node
+func (n *blockTreeNode) addText(title, text string) *blockTreeNode {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return n
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		return n.add(newTreeNode("%s: %s", title, lines[0]))
+	}
+
+	node := newTreeNode("%s (%d lines)", title, len(lines))
+	for _, line := range lines {
+		node.add(newTreeNode("%s", line))
+	}
+	return n.add(node)
+}
+
+// blockTree decodes the payload of a bstream block into its tree
+func blockTree(block *pbbstream.Block) (*blockTreeNode, error) {
+	switch block.PayloadKind {
+	case pbbstream.Protocol_EOS:
+		eosBlock := &pbdeos.Block{}
+		if err := proto.Unmarshal(block.PayloadBuffer, eosBlock); err != nil {
+			return nil, fmt.Errorf("decoding EOS block %d: %s", block.Number, err)
+		}
+		return eosBlockTree(eosBlock), nil
+
+	case pbbstream.Protocol_ETH:
+		ethBlock := &pbdeth.Block{}
+		if err := proto.Unmarshal(block.PayloadBuffer, ethBlock); err != nil {
+			return nil, fmt.Errorf("decoding ETH block %d: %s", block.Number, err)
+		}
+		return ethBlockTree(ethBlock), nil
+	}
+
+	return nil, fmt.Errorf("block %d: unsupported payload kind %s", block.Number, block.PayloadKind)
+}
+
+func formatTreeTime(ts *tspb.Timestamp) string {
+	tm, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return "?"
+	}
+	return tm.UTC().Format(time.RFC3339Nano)
+}
+
+// trimEnumPrefix turns `OPERATION_INSERT` into `INSERT`
+func trimEnumPrefix(value fmt.Stringer, prefix string) string {
+	return strings.TrimPrefix(value.String(), prefix)
+}
+
+func eosBlockTree(block *pbdeos.Block) *blockTreeNode {
+	root := newTreeNode("EOS block #%d %s", block.Number, block.Id)
+	if header := block.Header; header != nil {
+		root.add(newTreeNode("Header").add(
+			newTreeNode("timestamp: %s", formatTreeTime(header.Timestamp)),
+			newTreeNode("producer: %s", header.Producer),
+			newTreeNode("previous: %s", header.Previous),
+			newTreeNode("confirmed: %d, schedule version: %d", header.Confirmed, header.ScheduleVersion),
+		))
+	}
+	root.add(newTreeNode("irreversible: dpos %d, proposed %d", block.DposIrreversibleBlocknum, block.DposProposedIrreversibleBlocknum))
+
+	var traces []*blockTreeNode
+	for _, trace := range block.TransactionTraces {
+		traces = append(traces, eosTransactionTree(trace))
+	}
+	root.addList("Transaction traces", traces)
+
+	var trxOps []*blockTreeNode
+	for _, op := range block.TrxOps {
+		trxOps = append(trxOps, newTreeNode("%s %s %s", trimEnumPrefix(op.Operation, "OPERATION_"), op.Name, op.TransactionId))
+	}
+	root.addList("Transaction ops", trxOps)
+
+	root.expanded = true
+	return root
+}
+
+func eosTransactionTree(trace *pbdeos.TransactionTrace) *blockTreeNode {
+	status := "?"
+	if trace.Receipt != nil {
+		status = trimEnumPrefix(trace.Receipt.Status, "TRANSACTIONSTATUS_")
+	}
+
+	node := newTreeNode("trx %s %s, %d actions", trace.Id, status, len(trace.ActionTraces))
+	if receipt := trace.Receipt; receipt != nil {
+		node.add(newTreeNode("receipt: cpu %dus, net %d words, elapsed %dus", receipt.CpuUsageMicroSeconds, receipt.NetUsageWords, trace.Elapsed))
+	}
+	if trace.Scheduled {
+		node.add(newTreeNode("scheduled"))
+	}
+	node.add(eosExceptionTree(trace.Exception))
+
+	node.addList("Actions", eosActionTrees(trace))
+
+	var permOps []*blockTreeNode
+	for _, op := range trace.PermOps {
+		permOps = append(permOps, newTreeNode("%s action #%d", trimEnumPrefix(op.Operation, "OPERATION_"), op.ActionIndex))
+	}
+	node.addList("Perm ops", permOps)
+
+	var dtrxOps []*blockTreeNode
+	for _, op := range trace.DtrxOps {
+		dtrxOps = append(dtrxOps, newTreeNode("%s %s payer %s, action #%d", trimEnumPrefix(op.Operation, "OPERATION_"), op.TransactionId, op.Payer, op.ActionIndex))
+	}
+	node.addList("Deferred trx ops", dtrxOps)
+
+	if trace.FailedDtrxTrace != nil {
+		node.add(newTreeNode("Failed deferred trx").add(eosTransactionTree(trace.FailedDtrxTrace)))
+	}
+	return node
+}
+
+func eosExceptionTree(exception *pbdeos.Exception) *blockTreeNode {
+	if exception == nil {
+		return nil
+	}
+
+	node := newTreeNode("exception %d %s: %s", exception.Code, exception.Name, exception.Message)
+	for _, message := range exception.Stack {
+		node.add(newTreeNode("%s", message.Format))
+	}
+	return node
+}
+
+// eosActionTrees nests actions under their creator (see `nestEOSActions`),
+// each with the ops it caused.
+func eosActionTrees(trace *pbdeos.TransactionTrace) []*blockTreeNode {
+	opsByAction := eosOpsByAction(trace)
+
+	var convert func(nodes []*eosActionNode) []*blockTreeNode
+	convert = func(nodes []*eosActionNode) (out []*blockTreeNode) {
+		for _, action := range nodes {
+			if action.trace == nil {
+				out = append(out, newTreeNode("action #%d missing from the trace", action.ExecutionIndex))
+				continue
+			}
+
+			node := eosActionTree(action.ExecutionIndex, action.trace)
+			node.add(opsByAction[action.ExecutionIndex]...)
+			node.add(convert(action.Children)...)
+			out = append(out, node)
+		}
+		return out
+	}
+	return convert(nestEOSActions(trace))
+}
+
+func eosActionTree(index uint32, trace *pbdeos.ActionTrace) *blockTreeNode {
+	account, name := "?", "?"
+	action := trace.Action
+	if action != nil {
+		account, name = action.Account, action.Name
+	}
+
+	label := fmt.Sprintf("#%d %s::%s", index, account, name)
+	if trace.Receiver != account {
+		label += " (notify " + trace.Receiver + ")"
+	}
+	if trace.Exception != nil {
+		label += " FAILED"
+	}
+	node := newTreeNode("%s", label)
+
+	if action != nil {
+		var auths []string
+		for _, auth := range action.Authorization {
+			auths = append(auths, auth.Actor+"@"+auth.Permission)
+		}
+		if len(auths) != 0 {
+			node.add(newTreeNode("auth: %s", strings.Join(auths, ", ")))
+		}
+
+		if action.JsonData != "" {
+			node.add(newTreeNode("data: %s", action.JsonData))
+		} else if len(action.RawData) != 0 {
+			node.add(newTreeNode("raw data: %s", hex.EncodeToString(action.RawData)))
+		}
+	}
+
+	node.add(newTreeNode("elapsed: %dus", trace.Elapsed))
+	if receipt := trace.Receipt; receipt != nil {
+		node.add(newTreeNode("global sequence: %d", receipt.GlobalSequence))
+	}
+
+	var ramDeltas []*blockTreeNode
+	for _, delta := range trace.AccountRamDeltas {
+		ramDeltas = append(ramDeltas, newTreeNode("%s %+d bytes", delta.Account, delta.Delta))
+	}
+	node.addList("Account RAM deltas", ramDeltas)
+
+	node.addText("console", trace.Console)
+	node.add(eosExceptionTree(trace.Exception))
+	return node
+}
+
+// eosOpsByAction groups the DB, RAM and table ops of a transaction by the
+// execution index of the action that caused them.
+func eosOpsByAction(trace *pbdeos.TransactionTrace) map[uint32][]*blockTreeNode {
+	dbOps := map[uint32][]*blockTreeNode{}
+	for _, op := range trace.DbOps {
+		dbOps[op.ActionIndex] = append(dbOps[op.ActionIndex], eosDBOpTree(op))
+	}
+
+	ramOps := map[uint32][]*blockTreeNode{}
+	for _, op := range trace.RamOps {
+		ramOps[op.ActionIndex] = append(ramOps[op.ActionIndex], eosRAMOpTree(op))
+	}
+
+	tableOps := map[uint32][]*blockTreeNode{}
+	for _, op := range trace.TableOps {
+		tableOps[op.ActionIndex] = append(tableOps[op.ActionIndex], eosTableOpTree(op))
+	}
+
+	out := map[uint32][]*blockTreeNode{}
+	for index := range trace.ActionTraces {
+		index := uint32(index)
+		holder := &blockTreeNode{}
+		holder.addList("DB ops", dbOps[index])
+		holder.addList("RAM ops", ramOps[index])
+		holder.addList("Table ops", tableOps[index])
+		out[index] = holder.children
+	}
+	return out
+}
+
+func eosDBOpTree(op *pbdeos.DBOp) *blockTreeNode {
+	payer := op.NewPayer
+	if op.OldPayer != "" && op.OldPayer != op.NewPayer {
+		payer = op.OldPayer + " -> " + op.NewPayer
+	}
+
+	node := newTreeNode("%s %s/%s/%s pk %s payer %s", trimEnumPrefix(op.Operation, "OPERATION_"), op.Code, op.Scope, op.TableName, op.PrimaryKey, payer)
+	if len(op.OldData) != 0 {
+		node.add(newTreeNode("old: %s", hex.EncodeToString(op.OldData)))
+	}
+	if len(op.NewData) != 0 {
+		node.add(newTreeNode("new: %s", hex.EncodeToString(op.NewData)))
+	}
+	return node
+}
+
+func eosRAMOpTree(op *pbdeos.RAMOp) *blockTreeNode {
+	return newTreeNode("%s %+d bytes (usage %d) %s/%s %s",
+		op.Payer, op.Delta, op.Usage,
+		trimEnumPrefix(op.Namespace, "NAMESPACE_"), trimEnumPrefix(op.Action, "ACTION_"), op.UniqueKey,
+	)
+}
+
+func eosTableOpTree(op *pbdeos.TableOp) *blockTreeNode {
+	return newTreeNode("%s %s/%s/%s payer %s", trimEnumPrefix(op.Operation, "OPERATION_"), op.Code, op.Scope, op.TableName, op.Payer)
+}
+
+func ethBlockTree(block *pbdeth.Block) *blockTreeNode {
+	root := newTreeNode("ETH block #%d 0x%x", block.Number, block.Hash)
+	if header := block.Header; header != nil {
+		root.add(newTreeNode("Header").add(
+			newTreeNode("timestamp: %s", formatTreeTime(header.Timestamp)),
+			newTreeNode("parent: 0x%x", header.ParentHash),
+			newTreeNode("coinbase: 0x%x", header.Coinbase),
+			newTreeNode("gas: %d / %d", header.GasUsed, header.GasLimit),
+		))
+	}
+
+	var traces []*blockTreeNode
+	for _, trace := range block.TransactionTraces {
+		traces = append(traces, ethTransactionTree(trace))
+	}
+	root.addList("Transaction traces", traces)
+
+	var balanceChanges []*blockTreeNode
+	for _, change := range block.BalanceChanges {
+		balanceChanges = append(balanceChanges, ethBalanceChangeTree(change))
+	}
+	root.addList("Block balance changes", balanceChanges)
+
+	root.expanded = true
+	return root
+}
+
+func ethTransactionTree(trace *pbdeth.TransactionTrace) *blockTreeNode {
+	node := newTreeNode("trx 0x%x %s, %d calls", trace.Hash, trace.Status, len(trace.Calls))
+	node.add(
+		newTreeNode("from 0x%x to 0x%x", trace.From, trace.To),
+		newTreeNode("value: %s wei", ethBigInt(trace.Value)),
+		newTreeNode("gas: %d used / %d limit, price %s", trace.GasUsed, trace.GasLimit, ethBigInt(trace.GasPrice)),
+	)
+	node.addList("Calls", ethCallTrees(trace.Calls))
+
+	var netChanges []*blockTreeNode
+	for _, change := range ethNetBalanceChanges(trace.Calls) {
+		netChanges = append(netChanges, newTreeNode("%s %s wei", change.Address, change.Delta))
+	}
+	node.addList("Net balance changes", netChanges)
+	return node
+}
+
+// ethCallTrees nests calls under their parent (see `nestETHCalls`), their
+// details before the calls they made.
+func ethCallTrees(calls []*pbdeth.Call) []*blockTreeNode {
+	var convert func(nodes []*ethCallNode) []*blockTreeNode
+	convert = func(nodes []*ethCallNode) (out []*blockTreeNode) {
+		for _, call := range nodes {
+			node := ethCallTree(call.call)
+			if call.StateReverted && !call.Failed && !call.Reverted {
+				node.label += " (reverted by a parent call)"
+			}
+			node.add(convert(call.Children)...)
+			out = append(out, node)
+		}
+		return out
+	}
+	return convert(nestETHCalls(calls))
+}
+
+func ethCallTree(call *pbdeth.Call) *blockTreeNode {
+	label := fmt.Sprintf("#%d %s 0x%x -> 0x%x, gas %d / %d", call.Index, call.CallType, call.Caller, call.Address, call.GasConsumed, call.GasLimit)
+	if value := ethBigInt(call.Value); value != "0" {
+		label += ", value " + value + " wei"
+	}
+	if call.Failed {
+		label += " FAILED"
+	}
+	if call.Reverted {
+		label += " REVERTED"
+	}
+	node := newTreeNode("%s", label)
+
+	if call.FailureReason != "" {
+		node.add(newTreeNode("failure: %s", call.FailureReason))
+	}
+	if len(call.Input) != 0 {
+		inputNode := newTreeNode("input: 0x%x", call.Input)
+		if decoded := ethDecodeInput(call); decoded != nil {
+			inputNode = newTreeNode("input: %s", decoded).add(inputNode)
+		}
+		node.add(inputNode)
+	}
+
+	var logs []*blockTreeNode
+	for _, log := range call.Logs {
+		logNode := newTreeNode("#%d 0x%x", log.Index, log.Address)
+		if decoded := ethABIs.decodeLog(log.Address, log.Topics, log.Data); decoded != nil {
+			logNode.label += " " + decoded.String()
+		}
+		for i, topic := range log.Topics {
+			logNode.add(newTreeNode("topic %d: 0x%x", i, topic))
+		}
+		if len(log.Data) != 0 {
+			logNode.add(newTreeNode("data: 0x%x", log.Data))
+		}
+		logs = append(logs, logNode)
+	}
+	node.addList("Logs", logs)
+
+	var storageChanges []*blockTreeNode
+	for _, change := range call.StorageChanges {
+		storageChanges = append(storageChanges, newTreeNode("0x%x [0x%x] 0x%x -> 0x%x", change.Address, change.Key, change.OldValue, change.NewValue))
+	}
+	node.addList("Storage changes", storageChanges)
+
+	var balanceChanges []*blockTreeNode
+	for _, change := range call.BalanceChanges {
+		balanceChanges = append(balanceChanges, ethBalanceChangeTree(change))
+	}
+	node.addList("Balance changes", balanceChanges)
+
+	var gasChanges []*blockTreeNode
+	for _, change := range call.GasChanges {
+		gasChanges = append(gasChanges, newTreeNode("%d -> %d %s", change.OldValue, change.NewValue, trimEnumPrefix(change.Reason, "REASON_")))
+	}
+	node.addList("Gas changes", gasChanges)
+
+	return node
+}
+
+func ethBalanceChangeTree(change *pbdeth.BalanceChange) *blockTreeNode {
+	return newTreeNode("0x%x %s -> %s %s", change.Address, ethBigInt(change.OldValue), ethBigInt(change.NewValue), trimEnumPrefix(change.Reason, "REASON_"))
+}
+
+// ethBigInt formats a big endian integer in base 10, nil being 0
+func ethBigInt(value *pbdeth.BigInt) string {
+	if value == nil {
+		return "0"
+	}
+	return new(big.Int).SetBytes(value.Bytes).String()
+}
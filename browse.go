@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const browseHelp = `Browse the blocks of a dbin file (zstd compressed or not) or of a merged
+blocks store, in a terminal UI. EOS action traces are nested following their
+creation tree, with their DB, RAM and table ops, and ETH calls following their
+parent call.
+
+Keys:
+    up/k, down/j, pgup, pgdn/space, home/g, end/G   move
+    right/l, left/h                                 expand, collapse (or go to parent)
+    enter                                           toggle
+    e, c                                            expand, collapse everything below
+    n, p                                            next, previous block
+    q, ctrl-c                                       quit`
+
+var browseCmd = &cobra.Command{Use: "browse <file|store>", Short: "browse decoded EOS and ETH blocks in a terminal UI", Long: browseHelp, Args: cobra.ExactArgs(1), RunE: browse}
+
+func init() {
+	rootCmd.AddCommand(browseCmd)
+
+	browseCmd.Flags().Int64("start-block", 0, "skip blocks before this one, merged blocks stores starting at its bundle")
+	addETHABIFlags(browseCmd)
+}
+
+func browse(cmd *cobra.Command, args []string) (err error) {
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !terminal.IsTerminal(stdin) || !terminal.IsTerminal(stdout) {
+		return fmt.Errorf("doh browse needs a terminal, use doh dbin to decode blocks in scripts")
+	}
+
+	if err := loadETHABIs("browse-cmd"); err != nil {
+		return err
+	}
+
+	source, err := newBlockSource(args[0], uint64(viper.GetInt64("browse-cmd-start-block")))
+	if err != nil {
+		return err
+	}
+	defer source.close()
+
+	blocks := &browseBlocks{blockSource: source}
+
+	b := &browser{source: args[0], blocks: blocks, out: bufio.NewWriter(os.Stdout), current: -1}
+	if err := b.showBlock(0); err != nil {
+		return err
+	}
+
+	state, err := terminal.MakeRaw(stdin)
+	if err != nil {
+		return fmt.Errorf("setting terminal raw mode: %s", err)
+	}
+	defer terminal.Restore(stdin, state)
+
+	// Alternate screen, hidden cursor, both restored on the way out
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	input := make([]byte, 32)
+	for {
+		b.render()
+
+		n, err := os.Stdin.Read(input)
+		if err != nil {
+			return err
+		}
+		for _, key := range splitKeys(string(input[:n])) {
+			if quit := b.handleKey(key); quit {
+				return nil
+			}
+		}
+	}
+}
+
+// splitKeys splits a terminal read, which can hold several key presses
+// (held or pasted keys), into single characters and escape sequences
+// (`\x1b[` or `\x1bO` up to their final byte, like `\x1b[A` or `\x1b[5~`).
+func splitKeys(input string) (keys []string) {
+	for len(input) > 0 {
+		size := 1
+		switch {
+		case len(input) > 2 && input[0] == '\x1b' && input[1] == 'O':
+			size = 3
+		case len(input) > 1 && input[0] == '\x1b' && input[1] == '[':
+			size = 2
+			for size < len(input) && (input[size] < 0x40 || input[size] > 0x7e) {
+				size++
+			}
+			if size < len(input) {
+				size++
+			}
+		case input[0] != '\x1b':
+			_, size = utf8.DecodeRuneInString(input)
+		}
+
+		keys = append(keys, input[:size])
+		input = input[size:]
+	}
+	return keys
+}
+
+// browseBlocks loads blocks as they are paged through, keeping the ones
+// already seen (and their tree, with what was expanded) to page back.
+type browseBlocks struct {
+	*blockSource
+
+	loaded []*pbbstream.Block
+	trees  []*blockTreeNode
+	done   bool
+}
+
+// get returns the block at `index`, loading it when needed, nil past the end
+func (b *browseBlocks) get(index int) (*pbbstream.Block, *blockTreeNode, error) {
+	for index >= len(b.loaded) && !b.done {
+		batch, err := b.next()
+		if err == io.EOF {
+			b.done = true
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		b.loaded = append(b.loaded, batch...)
+		b.trees = append(b.trees, make([]*blockTreeNode, len(batch))...)
+	}
+	if index >= len(b.loaded) {
+		return nil, nil, nil
+	}
+
+	if b.trees[index] == nil {
+		tree, err := blockTree(b.loaded[index])
+		if err != nil {
+			// Shown in place of the block, to be able to page past it
+			tree = newTreeNode("block #%d: %s", b.loaded[index].Number, err)
+		}
+		b.trees[index] = tree
+	}
+	return b.loaded[index], b.trees[index], nil
+}
+
+// browseLine is a visible node, with its depth and the line of its parent
+type browseLine struct {
+	node   *blockTreeNode
+	depth  int
+	parent int
+}
+
+type browser struct {
+	source string
+	blocks *browseBlocks
+	out    *bufio.Writer
+
+	current int
+	block   *pbbstream.Block
+	root    *blockTreeNode
+	lines   []browseLine
+	cursor  int
+	offset  int
+	message string
+}
+
+// showBlock moves to the block at `index`, staying put when there's none
+func (b *browser) showBlock(index int) error {
+	if index < 0 {
+		b.message = "first block"
+		return nil
+	}
+
+	block, tree, err := b.blocks.get(index)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		if b.current == -1 {
+			return fmt.Errorf("no blocks in %s", b.source)
+		}
+		b.message = "last block"
+		return nil
+	}
+
+	b.current, b.block, b.root = index, block, tree
+	b.cursor, b.offset = 0, 0
+	b.refresh()
+	return nil
+}
+
+// refresh flattens the expanded nodes into lines
+func (b *browser) refresh() {
+	b.lines = b.lines[:0]
+
+	var walk func(node *blockTreeNode, depth, parent int)
+	walk = func(node *blockTreeNode, depth, parent int) {
+		b.lines = append(b.lines, browseLine{node: node, depth: depth, parent: parent})
+		if !node.expanded {
+			return
+		}
+
+		line := len(b.lines) - 1
+		for _, child := range node.children {
+			walk(child, depth+1, line)
+		}
+	}
+	walk(b.root, 0, -1)
+
+	if b.cursor >= len(b.lines) {
+		b.cursor = len(b.lines) - 1
+	}
+}
+
+func setExpanded(node *blockTreeNode, expanded bool) {
+	node.expanded = expanded && len(node.children) != 0
+	for _, child := range node.children {
+		setExpanded(child, expanded)
+	}
+}
+
+// handleKey applies a key press, returning true to quit
+func (b *browser) handleKey(key string) bool {
+	b.message = ""
+	_, height := b.size()
+	page := height - 2
+
+	line := b.lines[b.cursor]
+	switch key {
+	case "q", "\x03":
+		return true
+
+	case "\x1b[A", "k":
+		b.cursor--
+	case "\x1b[B", "j":
+		b.cursor++
+	case "\x1b[5~":
+		b.cursor -= page
+	case "\x1b[6~", " ":
+		b.cursor += page
+	case "\x1b[H", "\x1b[1~", "g":
+		b.cursor = 0
+	case "\x1b[F", "\x1b[4~", "G":
+		b.cursor = len(b.lines) - 1
+
+	case "\x1b[C", "l":
+		if len(line.node.children) == 0 {
+			break
+		}
+		if line.node.expanded {
+			b.cursor++
+			break
+		}
+		line.node.expanded = true
+	case "\x1b[D", "h":
+		if line.node.expanded {
+			line.node.expanded = false
+		} else if line.parent != -1 {
+			b.cursor = line.parent
+		}
+	case "\r", "\n":
+		line.node.expanded = !line.node.expanded && len(line.node.children) != 0
+	case "e":
+		setExpanded(line.node, true)
+	case "c":
+		setExpanded(line.node, false)
+
+	case "n":
+		if err := b.showBlock(b.current + 1); err != nil {
+			b.message = err.Error()
+		}
+		return false
+	case "p":
+		if err := b.showBlock(b.current - 1); err != nil {
+			b.message = err.Error()
+		}
+		return false
+	}
+
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	b.refresh()
+	return false
+}
+
+func (b *browser) size() (width, height int) {
+	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 2 {
+		return 80, 24
+	}
+	return width, height
+}
+
+func (b *browser) render() {
+	width, height := b.size()
+	page := height - 2
+
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+page {
+		b.offset = b.cursor - page + 1
+	}
+
+	header := fmt.Sprintf(" %s  block #%d (%d loaded)", b.source, b.block.Number, len(b.blocks.loaded))
+	b.out.WriteString("\x1b[H\x1b[7m" + fitWidth(header, width, true) + "\x1b[0m\r\n")
+
+	for row := 0; row < page; row++ {
+		index := b.offset + row
+		if index >= len(b.lines) {
+			b.out.WriteString("\x1b[K\r\n")
+			continue
+		}
+
+		line := b.lines[index]
+		marker := "  "
+		if len(line.node.children) != 0 {
+			marker = "+ "
+			if line.node.expanded {
+				marker = "- "
+			}
+		}
+
+		text := fitWidth(strings.Repeat("  ", line.depth)+marker+line.node.label, width, index == b.cursor)
+		if index == b.cursor {
+			text = "\x1b[7m" + text + "\x1b[0m"
+		}
+		b.out.WriteString(text + "\x1b[K\r\n")
+	}
+
+	footer := b.message
+	if footer == "" {
+		footer = "arrows/hjkl move, enter toggle, e/c expand/collapse all, n/p next/prev block, q quit"
+	}
+	b.out.WriteString("\x1b[2m" + fitWidth(footer, width, false) + "\x1b[0m\x1b[K")
+	b.out.Flush()
+}
+
+// fitWidth cuts `text` to `width` characters, padding it when asked to, and
+// replaces control characters which would mess the screen up.
+func fitWidth(text string, width int, pad bool) string {
+	text = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, text)
+
+	if count := utf8.RuneCountInString(text); count > width {
+		runes := []rune(text)
+		text = string(runes[:width-1]) + "…"
+	} else if pad {
+		text += strings.Repeat(" ", width-count)
+	}
+	return text
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitKeys(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{"j", []string{"j"}},
+		{"jjj", []string{"j", "j", "j"}},
+		{"\x1b[A", []string{"\x1b[A"}},
+		{"\x1b[A\x1b[A\x1b[B", []string{"\x1b[A", "\x1b[A", "\x1b[B"}},
+		{"\x1b[5~\x1b[6~", []string{"\x1b[5~", "\x1b[6~"}},
+		{"\x1bOHj", []string{"\x1bOH", "j"}},
+		{"k\x1b[1;5Cq", []string{"k", "\x1b[1;5C", "q"}},
+		{"\x1b", []string{"\x1b"}},
+		{"\x1b[", []string{"\x1b["}},
+		{"é\r", []string{"é", "\r"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			if actual := splitKeys(test.in); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// btSchemaPresets are the tables of each dfuse schema, with their column
+// families. Tables are named `<name-prefix>-<table>`, for example
+// `eos-dev-v1-trxs`. Every family keeps only its latest cell version.
+var btSchemaPresets = map[string]map[string][]string{
+	"eos-v1": {
+		"blocks":   {"block", "trxs", "meta"},
+		"trxs":     {"trx", "trace", "dtrx", "meta"},
+		"timeline": {"meta"},
+	},
+	"eth-v1": {
+		"blocks":   {"block", "meta"},
+		"trxs":     {"trx", "meta"},
+		"timeline": {"meta"},
+	},
+}
+
+func btDescribe(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	adminClient, err := newBigTableAdminClient(project, instance)
+	if err != nil {
+		return fmt.Errorf("init bigtable admin client: %s", err)
+	}
+	defer adminClient.Close()
+
+	ctx := context.Background()
+	info, err := adminClient.TableInfo(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("table info: %s", err)
+	}
+
+	families := info.FamilyInfos
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+
+	fmt.Printf("Table %s:%s/%s\n", project, instance, args[0])
+	fmt.Println("")
+	fmt.Println("Column families:")
+	for _, family := range families {
+		gcPolicy := family.GCPolicy
+		if gcPolicy == "" {
+			gcPolicy = "(none, keeps all versions)"
+		}
+		fmt.Printf("- %s  gc: %s\n", family.Name, gcPolicy)
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Sample row keys are the tablets boundaries, that's as close as we get to region stats
+	sampleKeys, err := client.Open(args[0]).SampleRowKeys(ctx)
+	if err != nil {
+		fmt.Println("")
+		fmt.Println("Tablets: unavailable:", err)
+		return nil
+	}
+
+	fmt.Println("")
+	fmt.Printf("Tablets (approximately): %d\n", len(sampleKeys)+1)
+	if viper.GetBool("bt-describe-cmd-show-splits") {
+		for _, key := range sampleKeys {
+			fmt.Printf("- %q\n", key)
+		}
+	}
+
+	return nil
+}
+
+func btCreate(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	if err := btCheckDestructiveAllowed(project, instance, "create tables "+args[0]+"-*"); err != nil {
+		return err
+	}
+
+	tables, err := btSchemaTables(viper.GetString("bt-create-cmd-schema"), viper.GetStringSlice("bt-create-cmd-tables"))
+	if err != nil {
+		return err
+	}
+
+	adminClient, err := newBigTableAdminClient(project, instance)
+	if err != nil {
+		return fmt.Errorf("init bigtable admin client: %s", err)
+	}
+	defer adminClient.Close()
+
+	ctx := context.Background()
+	for _, table := range tables {
+		name := args[0] + "-" + table.name
+		conf := &bigtable.TableConf{TableID: name, Families: map[string]bigtable.GCPolicy{}}
+		for _, family := range table.families {
+			conf.Families[family] = bigtable.MaxVersionsPolicy(1)
+		}
+
+		if err := adminClient.CreateTableFromConf(ctx, conf); err != nil {
+			return fmt.Errorf("creating table %q: %s", name, err)
+		}
+		fmt.Printf("Created table %s (families: %s)\n", name, strings.Join(table.families, ", "))
+	}
+
+	return nil
+}
+
+func btDrop(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	if err := btCheckDestructiveAllowed(project, instance, "drop tables "+strings.Join(args, ", ")); err != nil {
+		return err
+	}
+
+	adminClient, err := newBigTableAdminClient(project, instance)
+	if err != nil {
+		return fmt.Errorf("init bigtable admin client: %s", err)
+	}
+	defer adminClient.Close()
+
+	ctx := context.Background()
+	for _, table := range args {
+		if err := adminClient.DeleteTable(ctx, table); err != nil {
+			return fmt.Errorf("dropping table %q: %s", table, err)
+		}
+		fmt.Printf("Dropped table %s\n", table)
+	}
+
+	return nil
+}
+
+type btSchemaTable struct {
+	name     string
+	families []string
+}
+
+func btSchemaTables(schema string, only []string) (out []btSchemaTable, err error) {
+	preset, found := btSchemaPresets[schema]
+	if !found {
+		var known []string
+		for name := range btSchemaPresets {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown --schema %q, known schemas: %s", schema, strings.Join(known, ", "))
+	}
+
+	for name, families := range preset {
+		out = append(out, btSchemaTable{name: name, families: families})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+
+	if len(only) == 0 {
+		return out, nil
+	}
+
+	var filtered []btSchemaTable
+	for _, name := range only {
+		_, found := preset[name]
+		if !found {
+			return nil, fmt.Errorf("table %q is not part of schema %q", name, schema)
+		}
+		filtered = append(filtered, btSchemaTable{name: name, families: preset[name]})
+	}
+	return filtered, nil
+}
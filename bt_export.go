@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	zstd2 "github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const btExportHelp = `The export is a JSONL file (--file), zstd compressed when its name ends with
+".zst". The file is only created once rows are read. The first line is a header describing the source table, each
+following line holds one row with all its cells:
+
+    {"header": {"table": "eos-dev-v1-trxs", "project": "dev", "instance": "dev", "families": ["meta", "trx"], ...}}
+    {"key": "trx:abc", "cells": [{"column": "meta:written", "ts": 1585699200000000, "value": "dHJ1ZQ=="}]}
+
+"ts" is the cell timestamp in microseconds, "value" is base64 encoded. All
+cell versions are exported, unless --all-cells=false.`
+
+// zstdMagic starts every zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+type btExportRecord struct {
+	Header *btExportHeader `json:"header,omitempty"`
+	Key    string          `json:"key,omitempty"`
+	Cells  []btExportCell  `json:"cells,omitempty"`
+}
+
+type btExportHeader struct {
+	Table      string   `json:"table"`
+	Project    string   `json:"project"`
+	Instance   string   `json:"instance"`
+	Families   []string `json:"families,omitempty"`
+	ExportedAt string   `json:"exported_at"`
+}
+
+type btExportCell struct {
+	Column    string             `json:"column"`
+	Timestamp bigtable.Timestamp `json:"ts"`
+	Value     []byte             `json:"value"`
+}
+
+func btExport(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	if err := btConfirmScan(project, instance, "bt-export-cmd", args[0]); err != nil {
+		return err
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	header := &btExportHeader{
+		Table:      args[0],
+		Project:    project,
+		Instance:   instance,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	// Families are informative, used by `bt import --create`, don't fail the export without admin rights
+	if adminClient, err := newBigTableAdminClient(project, instance); err == nil {
+		if info, err := adminClient.TableInfo(ctx, args[0]); err == nil {
+			header.Families = info.Families
+			sort.Strings(header.Families)
+		}
+		adminClient.Close()
+	}
+
+	export := &btExportFile{name: viper.GetString("bt-export-cmd-file"), header: header}
+	defer export.abort()
+
+	rows, cells := 0, 0
+	var innerError error
+	parallel := viper.GetInt("bt-export-cmd-parallel")
+	ordered := !viper.GetBool("bt-export-cmd-unordered")
+	err = btReadRows(ctx, client.Open(args[0]), "bt-export-cmd", args[0], parallel, ordered, func(row bigtable.Row) bool {
+		record := btExportRecord{Key: row.Key()}
+		for _, items := range row {
+			for _, item := range items {
+				record.Cells = append(record.Cells, btExportCell{
+					Column:    item.Column,
+					Timestamp: item.Timestamp,
+					Value:     item.Value,
+				})
+			}
+		}
+
+		rows++
+		cells += len(record.Cells)
+		innerError = export.write(&record)
+		return innerError == nil
+	})
+	if err != nil {
+		return err
+	}
+	if innerError != nil {
+		return innerError
+	}
+
+	if err := export.close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d rows (%d cells) from %s:%s/%s\n", rows, cells, project, instance, args[0])
+	return nil
+}
+
+// btExportFile writes the export, only creating the file on the first
+// row (or when closing an empty export), so that a failing table read
+// doesn't truncate an existing file.
+type btExportFile struct {
+	name   string
+	header *btExportHeader
+
+	file    *os.File
+	buffer  *bufio.Writer
+	zstd    *zstd2.Encoder
+	encoder *json.Encoder
+}
+
+func (f *btExportFile) open() error {
+	var writer io.Writer = os.Stdout
+	if f.name != "-" {
+		file, err := os.Create(f.name)
+		if err != nil {
+			return err
+		}
+		f.file = file
+		writer = file
+	}
+
+	f.buffer = bufio.NewWriter(writer)
+	writer = f.buffer
+	if strings.HasSuffix(f.name, ".zst") {
+		encoder, err := zstd2.NewWriter(f.buffer)
+		if err != nil {
+			return fmt.Errorf("zstd writer: %s", err)
+		}
+		f.zstd = encoder
+		writer = encoder
+	}
+
+	f.encoder = json.NewEncoder(writer)
+	return f.encoder.Encode(&btExportRecord{Header: f.header})
+}
+
+func (f *btExportFile) write(record *btExportRecord) error {
+	if f.encoder == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	return f.encoder.Encode(record)
+}
+
+func (f *btExportFile) close() error {
+	if f.encoder == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	if f.zstd != nil {
+		if err := f.zstd.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.buffer.Flush(); err != nil {
+		return err
+	}
+	if f.file != nil {
+		file := f.file
+		f.file = nil
+		return file.Close()
+	}
+	return nil
+}
+
+// abort closes the file of a failed export, as far as it went
+func (f *btExportFile) abort() {
+	if f.file != nil {
+		f.file.Close()
+	}
+}
+
+func btImport(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	if err := btCheckDestructiveAllowed(project, instance, "import rows to "+args[0]); err != nil {
+		return err
+	}
+
+	var reader io.Reader = os.Stdin
+	if len(args) > 1 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	reader, err = maybeZstdReader(reader)
+	if err != nil {
+		return err
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	table := client.Open(args[0])
+	batchSize := viper.GetInt("bt-import-cmd-batch-size")
+
+	var keys []string
+	var muts []*bigtable.Mutation
+	rows, cells := 0, 0
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+
+		errs, err := table.ApplyBulk(ctx, keys, muts)
+		if err != nil {
+			return fmt.Errorf("apply bulk: %s", err)
+		}
+		for i, rowErr := range errs {
+			if rowErr != nil {
+				return fmt.Errorf("apply bulk: row %q: %s", keys[i], rowErr)
+			}
+		}
+
+		rows += len(keys)
+		keys, muts = nil, nil
+		return nil
+	}
+
+	decoder := json.NewDecoder(reader)
+	for line := 1; ; line++ {
+		var record btExportRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record #%d: %s", line, err)
+		}
+
+		if record.Header != nil {
+			fmt.Fprintf(os.Stderr, "Importing export of %s:%s/%s, taken at %s\n", record.Header.Project, record.Header.Instance, record.Header.Table, record.Header.ExportedAt)
+			if viper.GetBool("bt-import-cmd-create") {
+				if err := btImportCreateTable(ctx, project, instance, args[0], record.Header.Families); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if record.Key == "" {
+			return fmt.Errorf("record #%d: missing key", line)
+		}
+
+		mut := bigtable.NewMutation()
+		for _, cell := range record.Cells {
+			parts := strings.SplitN(cell.Column, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("record #%d (%q): invalid column %q", line, record.Key, cell.Column)
+			}
+			mut.Set(parts[0], parts[1], cell.Timestamp, cell.Value)
+		}
+		cells += len(record.Cells)
+
+		keys = append(keys, record.Key)
+		muts = append(muts, mut)
+		if len(keys) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d rows (%d cells) to %s:%s/%s\n", rows, cells, project, instance, args[0])
+	return nil
+}
+
+// btImportCreateTable creates the table with the exported families,
+// keeping all cell versions. An existing table gets its missing families.
+func btImportCreateTable(ctx context.Context, project, instance, table string, families []string) error {
+	adminClient, err := newBigTableAdminClient(project, instance)
+	if err != nil {
+		return fmt.Errorf("init bigtable admin client: %s", err)
+	}
+	defer adminClient.Close()
+
+	existing := map[string]bool{}
+	info, err := adminClient.TableInfo(ctx, table)
+	if err != nil {
+		if err := adminClient.CreateTable(ctx, table); err != nil {
+			return fmt.Errorf("creating table %q: %s", table, err)
+		}
+		fmt.Fprintf(os.Stderr, "Created table %s\n", table)
+	} else {
+		for _, family := range info.Families {
+			existing[family] = true
+		}
+	}
+
+	for _, family := range families {
+		if existing[family] {
+			continue
+		}
+		if err := adminClient.CreateColumnFamily(ctx, table, family); err != nil {
+			return fmt.Errorf("creating column family %q: %s", family, err)
+		}
+	}
+	return nil
+}
+
+// maybeZstdReader transparently decompresses `reader` when it starts with
+// the zstd magic bytes.
+func maybeZstdReader(reader io.Reader) (io.Reader, error) {
+	bufReader := bufio.NewReader(reader)
+	magic, err := bufReader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, zstdMagic) {
+		return bufReader, nil
+	}
+
+	zstdReader, err := zstd2.NewReader(bufReader)
+	if err != nil {
+		return nil, fmt.Errorf("zstd reader: %s", err)
+	}
+	return zstdReader, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addBTRowSelectionFlags registers the flags understood by
+// `btRowSelection`, for commands that read rows out of a table.
+func addBTRowSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("prefix", nil, "bigtable prefix key, repeat to read several prefixes")
+	cmd.Flags().String("start", "", "read rows starting at this key, inclusively")
+	cmd.Flags().String("end", "", "read rows up to this key, exclusively (empty means until the end of the table)")
+	cmd.Flags().StringSlice("key", nil, "read exactly these row keys, repeatable (exclusive with --prefix, --start and --end)")
+	cmd.Flags().String("key-regex", "", "only keep rows with keys fully matching this RE2 regexp")
+	cmd.Flags().String("family", "", "only keep cells in column families fully matching this RE2 regexp")
+	cmd.Flags().String("column", "", "only keep cells with column qualifiers fully matching this RE2 regexp")
+	cmd.Flags().String("ts-start", "", "only keep cells written at or after this time: "+timeExprHelp)
+	cmd.Flags().String("ts-end", "", "only keep cells written before this time: "+timeExprHelp)
+	cmd.Flags().Bool("all-cells", false, "List all cell values, instead of limiting to one timetsamp per cell, which is the default. Each column becomes an array of {ts, value}, newest first.")
+
+	cmd.Flags().StringSlice("key-layout", nil, "row key layouts, like 'trx:{trx_id}:{block_num:inv16}:{block_id_prefix}', overriding the table's preset (repeatable)")
+	cmd.Flags().String("trx-id", "", "read rows for this transaction ID, building the prefix from the table's key layout")
+	cmd.Flags().String("block-num", "", "read rows for this block number, building the prefix from the table's key layout")
+	cmd.Flags().String("block-id", "", "read rows for this block ID, building the prefix from the table's key layout")
+	cmd.Flags().String("account", "", "read rows for this account, building the prefix from the table's key layout")
+}
+
+// btRowSelection builds the row set and read options out of the flags
+// registered by `addBTRowSelectionFlags` (and `limit`, when the command
+// has one), `cmdKey` being the command's viper prefix (ex: `bt-read-cmd`).
+// The `extraFilters` are chained after the ones coming from flags.
+func btRowSelection(cmdKey, table string, extraFilters ...bigtable.Filter) (rowSet bigtable.RowSet, opts []bigtable.ReadOption, err error) {
+	rowSet, err = btRowSet(cmdKey, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var filters []bigtable.Filter
+	if pattern := viper.GetString(cmdKey + "-key-regex"); pattern != "" {
+		filters = append(filters, bigtable.RowKeyFilter(pattern))
+	}
+	if pattern := viper.GetString(cmdKey + "-family"); pattern != "" {
+		filters = append(filters, bigtable.FamilyFilter(pattern))
+	}
+	if pattern := viper.GetString(cmdKey + "-column"); pattern != "" {
+		filters = append(filters, bigtable.ColumnFilter(pattern))
+	}
+
+	start, err := btTimestampFlag(cmdKey, "ts-start", table)
+	if err != nil {
+		return nil, nil, err
+	}
+	end, err := btTimestampFlag(cmdKey, "ts-end", table)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start != 0 || end != 0 {
+		filters = append(filters, bigtable.TimestampRangeFilterMicros(start, end))
+	}
+
+	if !viper.GetBool(cmdKey+"-all-cells") && !viper.GetBool(cmdKey+"-history") {
+		filters = append(filters, latestCellOnly)
+	}
+	filters = append(filters, extraFilters...)
+
+	// Each `bigtable.RowFilter` option replaces the previous one, so they all need to be chained in a single one
+	switch len(filters) {
+	case 0:
+	case 1:
+		opts = append(opts, bigtable.RowFilter(filters[0]))
+	default:
+		opts = append(opts, bigtable.RowFilter(bigtable.ChainFilters(filters...)))
+	}
+
+	if limit := viper.GetInt(cmdKey + "-limit"); limit != 0 {
+		opts = append(opts, bigtable.LimitRows(int64(limit)))
+	}
+
+	return rowSet, opts, nil
+}
+
+// btKeyRange is a `[start, end)` row key range, an empty `end` meaning
+// until the end of the table.
+type btKeyRange struct {
+	start string
+	end   string
+}
+
+func (r btKeyRange) rowRange() bigtable.RowRange {
+	if r.end == "" {
+		return bigtable.InfiniteRange(r.start)
+	}
+	return bigtable.NewRange(r.start, r.end)
+}
+
+func btRowSet(cmdKey, table string) (bigtable.RowSet, error) {
+	ranges, keys, err := btSelectedRanges(cmdKey, table)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) != 0 {
+		return bigtable.RowList(keys), nil
+	}
+	return btRangesRowSet(ranges), nil
+}
+
+// btRangesRowSet reads the whole table when there are no `ranges`
+func btRangesRowSet(ranges []btKeyRange) bigtable.RowSet {
+	switch len(ranges) {
+	case 0:
+		return bigtable.InfiniteRange("")
+	case 1:
+		return ranges[0].rowRange()
+	}
+
+	var out bigtable.RowRangeList
+	for _, r := range ranges {
+		out = append(out, r.rowRange())
+	}
+	return out
+}
+
+// btSelectedRanges returns the key ranges selected by the flags, or the
+// exact `keys` with `--key`. Neither means the whole table.
+func btSelectedRanges(cmdKey, table string) (ranges []btKeyRange, keys []string, err error) {
+	prefixes := viper.GetStringSlice(cmdKey + "-prefix")
+
+	layouts, err := btKeyLayoutsForTable(cmdKey, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	typedPrefix, err := btTypedKeyPrefix(cmdKey, layouts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if typedPrefix != "" {
+		prefixes = append(prefixes, typedPrefix)
+	}
+
+	start := viper.GetString(cmdKey + "-start")
+	end := viper.GetString(cmdKey + "-end")
+	keys = viper.GetStringSlice(cmdKey + "-key")
+
+	if len(keys) != 0 {
+		if len(prefixes) != 0 || start != "" || end != "" {
+			return nil, nil, fmt.Errorf("--key cannot be combined with --prefix, --start or --end")
+		}
+		return nil, keys, nil
+	}
+
+	for _, prefix := range prefixes {
+		if prefix != "" {
+			ranges = append(ranges, btKeyRange{start: prefix, end: string(prefixNext([]byte(prefix)))})
+		}
+	}
+
+	if start != "" || end != "" {
+		if end != "" && end <= start {
+			return nil, nil, fmt.Errorf("--end %q must be after --start %q", end, start)
+		}
+		ranges = append(ranges, btKeyRange{start: start, end: end})
+	}
+
+	return ranges, nil, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+)
+
+// btCellVersion is one version of a cell, as output by `bt read --all-cells`.
+// In `--history` mode, only the oldest version has a `value`, the following
+// ones listing their `changes` compared to the previous version.
+type btCellVersion struct {
+	Timestamp string         `json:"ts"`
+	Value     interface{}    `json:"value,omitempty"`
+	Changes   []btCellChange `json:"changes,omitempty"`
+	Unchanged bool           `json:"unchanged,omitempty"`
+}
+
+type btCellChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+func formatBTTimestamp(ts bigtable.Timestamp) string {
+	return ts.Time().UTC().Format(time.RFC3339Nano)
+}
+
+// btCellHistory turns versions, as read from big table (newest first),
+// into the oldest value followed by the changes of each newer version.
+func btCellHistory(versions []btCellVersion) ([]btCellVersion, error) {
+	out := make([]btCellVersion, len(versions))
+	var previous interface{}
+	for i := range versions {
+		version := versions[len(versions)-1-i]
+
+		current, err := toJSONValue(version.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			out[i] = btCellVersion{Timestamp: version.Timestamp, Value: version.Value}
+		} else {
+			changes := jsonDiff("", previous, current, nil)
+			out[i] = btCellVersion{Timestamp: version.Timestamp, Changes: changes, Unchanged: len(changes) == 0}
+		}
+		previous = current
+	}
+	return out, nil
+}
+
+// toJSONValue brings decoded cells (`json.RawMessage` of protobuf decoded
+// ones, or strings) to plain maps, slices and scalars, ready for diffing.
+func toJSONValue(value interface{}) (interface{}, error) {
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		return value, nil
+	}
+
+	var out interface{}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decoding cell json: %s", err)
+	}
+	return out, nil
+}
+
+// jsonDiff appends the differences between `from` and `to` to `changes`,
+// recursing in objects and arrays, paths being dot separated.
+func jsonDiff(path string, from, to interface{}, changes []btCellChange) []btCellChange {
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		toValue, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := map[string]bool{}
+		for key := range fromValue {
+			keys[key] = true
+		}
+		for key := range toValue {
+			keys[key] = true
+		}
+
+		var sortedKeys []string
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+
+		for _, key := range sortedKeys {
+			changes = jsonDiff(joinJSONPath(path, key), fromValue[key], toValue[key], changes)
+		}
+		return changes
+
+	case []interface{}:
+		toValue, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(fromValue) || i < len(toValue); i++ {
+			var fromElem, toElem interface{}
+			if i < len(fromValue) {
+				fromElem = fromValue[i]
+			}
+			if i < len(toValue) {
+				toElem = toValue[i]
+			}
+			changes = jsonDiff(joinJSONPath(path, strconv.Itoa(i)), fromElem, toElem, changes)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return changes
+	}
+	return append(changes, btCellChange{Path: path, From: from, To: to})
+}
+
+func joinJSONPath(path, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "." + elem
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/spf13/viper"
+)
+
+// btKeyLayouts are the row key layouts of the dfuse v1 tables, keyed by
+// protocol and table name suffix. Segments are separated by `:`,
+// placeholders are `{name}` for plain strings or `{name:kind}` where `kind`
+// is one of:
+//
+//	num8, num16   block number (or time), as fixed width hex
+//	inv8, inv16   same, but inverted (`0xff..ff - value`) so that the
+//	              most recent sort first
+//
+// The last placeholder of a layout swallows any remaining `:`.
+var btKeyLayouts = map[pbbstream.Protocol]map[string][]string{
+	pbbstream.Protocol_EOS: {
+		"blocks":   {"{block_num:inv8}:{block_id}"},
+		"trxs":     {"trx:{trx_id}:{block_num:inv16}:{block_id_prefix}"},
+		"timeline": {"bf:{block_time:num16}:{block_id}", "bb:{block_time:inv16}:{block_id}"},
+		"accounts": {"a:{account}"},
+	},
+
+	// ETH block IDs are hashes, without the block number, and transaction
+	// keys have the block number as plain hex: `trx:<hash>:00000000007fffc6:<block hash prefix>`
+	// is in block 8388550. Other ETH tables need a `--key-layout`.
+	pbbstream.Protocol_ETH: {
+		"trxs": {"trx:{trx_id}:{block_num:num16}:{block_id_prefix}"},
+	},
+}
+
+type btKeySegment struct {
+	literal string
+	name    string
+	kind    string
+}
+
+type btKeyLayout []btKeySegment
+
+func parseBTKeyLayout(layout string) (out btKeyLayout, err error) {
+	for _, segment := range splitBTKeyLayout(layout) {
+		if !strings.HasPrefix(segment, "{") {
+			out = append(out, btKeySegment{literal: segment})
+			continue
+		}
+
+		if !strings.HasSuffix(segment, "}") {
+			return nil, fmt.Errorf("invalid key layout %q: unterminated placeholder %q", layout, segment)
+		}
+
+		inner := segment[1 : len(segment)-1]
+		name, kind := inner, ""
+		if idx := strings.IndexByte(inner, ':'); idx != -1 {
+			name, kind = inner[:idx], inner[idx+1:]
+		}
+
+		switch kind {
+		case "", "num8", "num16", "inv8", "inv16":
+		default:
+			return nil, fmt.Errorf("invalid key layout %q: unknown kind %q", layout, kind)
+		}
+
+		out = append(out, btKeySegment{name: name, kind: kind})
+	}
+	return out, nil
+}
+
+// splitBTKeyLayout splits on `:`, except within `{}` placeholders
+func splitBTKeyLayout(layout string) (out []string) {
+	depth := 0
+	last := 0
+	for i, c := range layout {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				out = append(out, layout[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(out, layout[last:])
+}
+
+// btKeyLayoutsForTable returns the `--key-layout` flag values when set,
+// or the preset layouts for the table, based on its protocol and name
+// suffix. Tables of an unknown protocol have no preset.
+func btKeyLayoutsForTable(cmdKey, table string) (out []btKeyLayout, err error) {
+	layouts := viper.GetStringSlice(cmdKey + "-key-layout")
+	if protocol, err := btTableProtocol(cmdKey, table); len(layouts) == 0 && err == nil {
+		for suffix, preset := range btKeyLayouts[protocol] {
+			if strings.HasSuffix(table, "-"+suffix) {
+				layouts = preset
+				break
+			}
+		}
+	}
+
+	for _, layout := range layouts {
+		parsed, err := parseBTKeyLayout(layout)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, parsed)
+	}
+	return out, nil
+}
+
+// parse splits `key` into its named parts, returning `nil` when the key
+// doesn't follow the layout.
+func (l btKeyLayout) parse(key string) map[string]interface{} {
+	chunks := strings.SplitN(key, ":", len(l))
+	if len(chunks) != len(l) {
+		return nil
+	}
+
+	out := map[string]interface{}{}
+	for i, segment := range l {
+		chunk := chunks[i]
+		if segment.name == "" {
+			if chunk != segment.literal {
+				return nil
+			}
+			continue
+		}
+
+		if segment.kind == "" {
+			out[segment.name] = chunk
+			continue
+		}
+
+		width, inverted := btKeyKindFormat(segment.kind)
+		if len(chunk) != width {
+			return nil
+		}
+
+		val, err := strconv.ParseUint(chunk, 16, width*4)
+		if err != nil {
+			return nil
+		}
+		if inverted {
+			val = btKeyKindMax(width) - val
+		}
+		out[segment.name] = val
+	}
+
+	return out
+}
+
+// prefix builds the longest key prefix possible out of `values`, stopping
+// at the first placeholder without a value. It returns false when not all
+// values were used, meaning the layout can't represent them.
+func (l btKeyLayout) prefix(values map[string]string) (string, bool, error) {
+	var out []string
+	used := 0
+
+	for i, segment := range l {
+		if segment.name == "" {
+			out = append(out, segment.literal)
+			continue
+		}
+
+		value, found := values[segment.name]
+		if !found {
+			if i != 0 {
+				// Keep the separator, so `trx:abc` doesn't also match `trx:abcdef`
+				out = append(out, "")
+			}
+			break
+		}
+		used++
+
+		if segment.kind == "" {
+			out = append(out, value)
+			continue
+		}
+
+		width, inverted := btKeyKindFormat(segment.kind)
+		val, err := strconv.ParseUint(value, 0, width*4)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid %s %q: %s", segment.name, value, err)
+		}
+		if inverted {
+			val = btKeyKindMax(width) - val
+		}
+		out = append(out, fmt.Sprintf("%0*x", width, val))
+	}
+
+	return strings.Join(out, ":"), used != 0 && used == len(values), nil
+}
+
+func btKeyKindFormat(kind string) (width int, inverted bool) {
+	switch kind {
+	case "num8":
+		return 8, false
+	case "inv8":
+		return 8, true
+	case "num16":
+		return 16, false
+	case "inv16":
+		return 16, true
+	}
+	panic(fmt.Errorf("unknown key kind %q", kind))
+}
+
+func btKeyKindMax(width int) uint64 {
+	if width >= 16 {
+		return ^uint64(0)
+	}
+	return 1<<(uint(width)*4) - 1
+}
+
+// parseBTKey tries each layout in turn, returning the first match
+func parseBTKey(layouts []btKeyLayout, key string) map[string]interface{} {
+	for _, layout := range layouts {
+		if parsed := layout.parse(key); parsed != nil {
+			return parsed
+		}
+	}
+	return nil
+}
+
+// btTypedKeyPrefix builds a row key prefix out of the typed key flags
+// (`--trx-id`, `--block-num`, ...), using the first layout that can hold
+// all of them. It returns an empty prefix when no typed flag was set.
+func btTypedKeyPrefix(cmdKey string, layouts []btKeyLayout) (string, error) {
+	values := map[string]string{}
+	for flag, name := range map[string]string{
+		"trx-id":    "trx_id",
+		"block-num": "block_num",
+		"block-id":  "block_id",
+		"account":   "account",
+	} {
+		if value := viper.GetString(cmdKey + "-" + flag); value != "" {
+			values[name] = value
+		}
+	}
+
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	for _, layout := range layouts {
+		layoutValues := values
+		if blockID, found := values["block_id"]; found && layout.has("block_id_prefix") && !layout.has("block_id") {
+			layoutValues = map[string]string{"block_id_prefix": blockID[:minInt(8, len(blockID))]}
+			for name, value := range values {
+				if name != "block_id" {
+					layoutValues[name] = value
+				}
+			}
+		}
+
+		prefix, ok, err := layout.prefix(layoutValues)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return prefix, nil
+		}
+	}
+
+	return "", fmt.Errorf("no key layout of this table can be built from the given --trx-id, --block-num, --block-id or --account (use --key-layout to define one)")
+}
+
+func (l btKeyLayout) has(name string) bool {
+	for _, segment := range l {
+		if segment.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
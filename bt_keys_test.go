@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/spf13/viper"
+)
+
+var eosKeyLayouts = btKeyLayouts[pbbstream.Protocol_EOS]
+var ethKeyLayouts = btKeyLayouts[pbbstream.Protocol_ETH]
+
+func TestParseBTKeyLayout(t *testing.T) {
+	tests := []struct {
+		layout      string
+		expected    btKeyLayout
+		expectedErr bool
+	}{
+		{"a:{account}", btKeyLayout{{literal: "a"}, {name: "account"}}, false},
+		{"{block_num:inv8}:{block_id}", btKeyLayout{{name: "block_num", kind: "inv8"}, {name: "block_id"}}, false},
+		{"bf:{block_time:num16}:{block_id}", btKeyLayout{{literal: "bf"}, {name: "block_time", kind: "num16"}, {name: "block_id"}}, false},
+		{"{n:num8}:{m:inv16}", btKeyLayout{{name: "n", kind: "num8"}, {name: "m", kind: "inv16"}}, false},
+		{"trx:{trx_id", nil, true},
+		{"trx:{trx_id:hex}", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.layout, func(t *testing.T) {
+			actual, err := parseBTKeyLayout(test.layout)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBTKeyLayoutParse(t *testing.T) {
+	tests := []struct {
+		layout   string
+		key      string
+		expected map[string]interface{}
+	}{
+		{eosKeyLayouts["blocks"][0], "ffffffce:0000003100aa", map[string]interface{}{"block_num": uint64(49), "block_id": "0000003100aa"}},
+		{eosKeyLayouts["blocks"][0], "ffffffff:00", map[string]interface{}{"block_num": uint64(0), "block_id": "00"}},
+		{eosKeyLayouts["trxs"][0], "trx:abcd:ffffffffffffffce:00000031", map[string]interface{}{"trx_id": "abcd", "block_num": uint64(49), "block_id_prefix": "00000031"}},
+		{eosKeyLayouts["timeline"][0], "bf:0000017133099c00:0000003100aa", map[string]interface{}{"block_time": uint64(1585699200000), "block_id": "0000003100aa"}},
+		{eosKeyLayouts["timeline"][1], "bb:fffffe8eccf663ff:0000003100aa", map[string]interface{}{"block_time": uint64(1585699200000), "block_id": "0000003100aa"}},
+		{eosKeyLayouts["accounts"][0], "a:eosio.token", map[string]interface{}{"account": "eosio.token"}},
+		{eosKeyLayouts["trxs"][0], "trx:abcd:ffffffffff800039:360131db", map[string]interface{}{"trx_id": "abcd", "block_num": uint64(8388550), "block_id_prefix": "360131db"}},
+		{ethKeyLayouts["trxs"][0], "trx:000170ffbb87f07ae38e505a14e5754a4eee028fe8eac217d34a1c9d112bf89b:00000000007fffc6:360131db", map[string]interface{}{"trx_id": "000170ffbb87f07ae38e505a14e5754a4eee028fe8eac217d34a1c9d112bf89b", "block_num": uint64(8388550), "block_id_prefix": "360131db"}},
+
+		// The last placeholder swallows the remaining separators
+		{eosKeyLayouts["accounts"][0], "a:with:colons", map[string]interface{}{"account": "with:colons"}},
+
+		{eosKeyLayouts["trxs"][0], "blk:abcd:ffffffffffffffce:00000031", nil},
+		{eosKeyLayouts["trxs"][0], "trx:abcd", nil},
+		{eosKeyLayouts["blocks"][0], "ffce:0000003100aa", nil},
+		{eosKeyLayouts["blocks"][0], "fffffzce:0000003100aa", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			layout, err := parseBTKeyLayout(test.layout)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual := layout.parse(test.key)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBTKeyLayoutPrefix(t *testing.T) {
+	tests := []struct {
+		layout      string
+		values      map[string]string
+		expected    string
+		expectedOK  bool
+		expectedErr bool
+	}{
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "49"}, "ffffffce:", true, false},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "0x31"}, "ffffffce:", true, false},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "0"}, "ffffffff:", true, false},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "4294967295"}, "00000000:", true, false},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "49", "block_id": "0000003100aa"}, "ffffffce:0000003100aa", true, false},
+		{eosKeyLayouts["trxs"][0], map[string]string{"trx_id": "abcd"}, "trx:abcd:", true, false},
+		{eosKeyLayouts["trxs"][0], map[string]string{"trx_id": "abcd", "block_num": "49"}, "trx:abcd:ffffffffffffffce:", true, false},
+		{eosKeyLayouts["timeline"][0], map[string]string{"block_time": "1585699200000"}, "bf:0000017133099c00:", true, false},
+		{eosKeyLayouts["timeline"][1], map[string]string{"block_time": "1585699200000"}, "bb:fffffe8eccf663ff:", true, false},
+
+		// Values after a missing placeholder can't be part of the prefix
+		{eosKeyLayouts["trxs"][0], map[string]string{"block_num": "49"}, "trx:", false, false},
+		{eosKeyLayouts["trxs"][0], map[string]string{"account": "bob"}, "trx:", false, false},
+
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "4294967296"}, "", false, true},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "-1"}, "", false, true},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "abc"}, "", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.layout+" "+test.expected, func(t *testing.T) {
+			layout, err := parseBTKeyLayout(test.layout)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual, ok, err := layout.prefix(test.values)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if actual != test.expected || ok != test.expectedOK {
+				t.Errorf("expected %q (%t), got %q (%t)", test.expected, test.expectedOK, actual, ok)
+			}
+		})
+	}
+}
+
+func TestBTKeyLayoutRoundTrip(t *testing.T) {
+	tests := []struct {
+		layout string
+		values map[string]string
+	}{
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "0", "block_id": "00000000aa"}},
+		{eosKeyLayouts["blocks"][0], map[string]string{"block_num": "4294967295", "block_id": "ffffffffaa"}},
+		{eosKeyLayouts["trxs"][0], map[string]string{"trx_id": "abcd", "block_num": "18446744073709551615", "block_id_prefix": "00000031"}},
+		{eosKeyLayouts["timeline"][1], map[string]string{"block_time": "1", "block_id": "00000031aa"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.layout, func(t *testing.T) {
+			layout, err := parseBTKeyLayout(test.layout)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			key, ok, err := layout.prefix(test.values)
+			if err != nil || !ok {
+				t.Fatalf("building key: %t, %v", ok, err)
+			}
+
+			parsed := layout.parse(key)
+			if parsed == nil {
+				t.Fatalf("key %q doesn't parse back", key)
+			}
+			for name, value := range test.values {
+				if actual := fmt.Sprint(parsed[name]); actual != value {
+					t.Errorf("%s: expected %s, got %s", name, value, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestBTKeyLayoutsForTable(t *testing.T) {
+	tests := []struct {
+		table    string
+		protocol string
+		expected []string
+	}{
+		{"eos-test-v1-blocks", "", eosKeyLayouts["blocks"]},
+		{"eos-test-v1-trxs", "", eosKeyLayouts["trxs"]},
+		{"eth-test-v1-trxs", "", ethKeyLayouts["trxs"]},
+		{"eth-test-v1-blocks", "", nil},
+		{"test-blocks", "EOS", eosKeyLayouts["blocks"]},
+		{"test-trxs", "", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.table, func(t *testing.T) {
+			viper.Set("test-cmd-protocol", test.protocol)
+			defer viper.Set("test-cmd-protocol", "")
+
+			actual, err := btKeyLayoutsForTable("test-cmd", test.table)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var expected []btKeyLayout
+			for _, layout := range test.expected {
+				parsed, _ := parseBTKeyLayout(layout)
+				expected = append(expected, parsed)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestBTTypedKeyPrefix(t *testing.T) {
+	var layouts []btKeyLayout
+	for _, layout := range eosKeyLayouts["trxs"] {
+		parsed, err := parseBTKeyLayout(layout)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		layouts = append(layouts, parsed)
+	}
+
+	tests := []struct {
+		flags       map[string]string
+		expected    string
+		expectedErr bool
+	}{
+		{map[string]string{}, "", false},
+		{map[string]string{"trx-id": "abcd"}, "trx:abcd:", false},
+		{map[string]string{"trx-id": "abcd", "block-num": "49"}, "trx:abcd:ffffffffffffffce:", false},
+		{map[string]string{"trx-id": "abcd", "block-num": "49", "block-id": "0000003100aabbcc"}, "trx:abcd:ffffffffffffffce:00000031", false},
+		{map[string]string{"account": "bob"}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			for _, flag := range []string{"trx-id", "block-num", "block-id", "account"} {
+				viper.Set("test-cmd-"+flag, test.flags[flag])
+			}
+
+			actual, err := btTypedKeyPrefix("test-cmd", layouts)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
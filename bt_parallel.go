@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Rows buffered per shard, waiting for the previous shards to be output
+const btShardBufferSize = 1000
+
+func addBTParallelFlags(cmd *cobra.Command, parallel int) {
+	cmd.Flags().Int("parallel", parallel, "scan this many shards of the table at once, split along the table's sample row keys (0 or 1 reads sequentially)")
+	cmd.Flags().Bool("unordered", false, "with --parallel, output rows as soon as they are read, instead of in key order")
+}
+
+// btReadRows reads the rows selected by the `cmdKey` flags, like
+// `btRowSelection` does, scanning `parallel` shards at once when above 1.
+// With `ordered`, `onRow` gets rows in key order, otherwise in whatever
+// order shards return them. `onRow` is never called concurrently.
+func btReadRows(ctx context.Context, table *bigtable.Table, cmdKey, tableName string, parallel int, ordered bool, onRow func(bigtable.Row) bool, extraFilters ...bigtable.Filter) error {
+	rowSet, opts, err := btRowSelection(cmdKey, tableName, extraFilters...)
+	if err != nil {
+		return err
+	}
+
+	if parallel <= 1 {
+		return table.ReadRows(ctx, rowSet, onRow, opts...)
+	}
+
+	ranges, keys, err := btSelectedRanges(cmdKey, tableName)
+	if err != nil {
+		return err
+	}
+
+	// Exact keys are a single request anyway
+	if len(keys) != 0 {
+		return table.ReadRows(ctx, rowSet, onRow, opts...)
+	}
+
+	sampleKeys, err := table.SampleRowKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("sample row keys: %s", err)
+	}
+
+	// More shards than workers, so that a slow shard doesn't hold everything
+	shards := btShards(ranges, sampleKeys, parallel*4)
+	limit := viper.GetInt(cmdKey + "-limit")
+
+	if ordered {
+		return btReadShardsOrdered(ctx, table, shards, parallel, limit, onRow, opts)
+	}
+	return btReadShardsUnordered(ctx, table, shards, parallel, limit, onRow, opts)
+}
+
+// btShards splits the selected `ranges` (the whole table when empty) along
+// at most `maxShards` of the sample keys, dropping empty shards.
+func btShards(ranges []btKeyRange, sampleKeys []string, maxShards int) (out []bigtable.RowSet) {
+	if len(ranges) == 0 {
+		ranges = []btKeyRange{{}}
+	}
+
+	var boundaries []string
+	step := float64(len(sampleKeys)+1) / float64(maxShards)
+	if step < 1 {
+		step = 1
+	}
+	for i := step; int(i) <= len(sampleKeys); i += step {
+		if key := sampleKeys[int(i)-1]; key != "" && (len(boundaries) == 0 || key > boundaries[len(boundaries)-1]) {
+			boundaries = append(boundaries, key)
+		}
+	}
+
+	shardStart := ""
+	for i := 0; i <= len(boundaries); i++ {
+		shardEnd := ""
+		if i < len(boundaries) {
+			shardEnd = boundaries[i]
+		}
+
+		var shardRanges []btKeyRange
+		for _, r := range ranges {
+			if intersection, ok := r.intersect(btKeyRange{start: shardStart, end: shardEnd}); ok {
+				shardRanges = append(shardRanges, intersection)
+			}
+		}
+		if len(shardRanges) != 0 {
+			out = append(out, btRangesRowSet(shardRanges))
+		}
+
+		shardStart = shardEnd
+	}
+
+	return out
+}
+
+func (r btKeyRange) intersect(other btKeyRange) (btKeyRange, bool) {
+	out := r
+	if other.start > out.start {
+		out.start = other.start
+	}
+	if out.end == "" || (other.end != "" && other.end < out.end) {
+		out.end = other.end
+	}
+	return out, out.end == "" || out.start < out.end
+}
+
+type btShardRows struct {
+	rows chan bigtable.Row
+	err  error
+}
+
+func btReadShardsOrdered(ctx context.Context, table *bigtable.Table, shards []bigtable.RowSet, parallel, limit int, onRow func(bigtable.Row) bool, opts []bigtable.ReadOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*btShardRows, len(shards))
+	for i := range results {
+		results[i] = &btShardRows{rows: make(chan bigtable.Row, btShardBufferSize)}
+	}
+
+	// Shards start in order, so the one being output always has a worker
+	go func() {
+		workers := make(chan struct{}, parallel)
+		for i, shard := range shards {
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(shard bigtable.RowSet, result *btShardRows) {
+				defer func() { <-workers }()
+				defer close(result.rows)
+
+				result.err = table.ReadRows(ctx, shard, func(row bigtable.Row) bool {
+					select {
+					case result.rows <- row:
+						return true
+					case <-ctx.Done():
+						return false
+					}
+				}, opts...)
+			}(shard, results[i])
+		}
+	}()
+
+	count := 0
+	for _, result := range results {
+		for row := range result.rows {
+			count++
+			if !onRow(row) || (limit != 0 && count >= limit) {
+				return nil
+			}
+		}
+		if result.err != nil {
+			return result.err
+		}
+	}
+	return nil
+}
+
+func btReadShardsUnordered(ctx context.Context, table *bigtable.Table, shards []bigtable.RowSet, parallel, limit int, onRow func(bigtable.Row) bool, opts []bigtable.ReadOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	var firstErr error
+	count := 0
+	stopped := false
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, parallel)
+	for _, shard := range shards {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(shard bigtable.RowSet) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			err := table.ReadRows(ctx, shard, func(row bigtable.Row) bool {
+				lock.Lock()
+				defer lock.Unlock()
+
+				if stopped {
+					return false
+				}
+
+				count++
+				if !onRow(row) || (limit != 0 && count >= limit) {
+					stopped = true
+					cancel()
+					return false
+				}
+				return true
+			}, opts...)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil && !stopped && firstErr == nil {
+				firstErr = err
+				stopped = true
+				cancel()
+			}
+		}(shard)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+func btCount(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	if err := btConfirmScan(project, instance, "bt-count-cmd", args[0]); err != nil {
+		return err
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	count := 0
+	parallel := viper.GetInt("bt-count-cmd-parallel")
+	err = btReadRows(context.Background(), client.Open(args[0]), "bt-count-cmd", args[0], parallel, false, func(row bigtable.Row) bool {
+		count++
+		return true
+	}, bigtable.StripValueFilter())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(count)
+	return nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/bigtable"
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/dfuse-io/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const btWriteHelp = `Each line of the input is a JSON object describing the mutations of one row:
+
+    {"key": "trx:abc", "ts": "2020-04-01T00:00:00Z", "set": {"meta:written": "true", "trace:proto": {"id": "abc", ...}}}
+    {"key": "trx:def", "delete": ["meta:written", "trace"]}
+    {"key": "trx:ghi", "delete_row": true}
+
+"set" values are written as raw bytes when they are JSON strings. Objects are
+encoded to protobuf, using the type known for that column with --protocol.
+"delete" takes "family:qualifier" columns, or whole "family" names. "ts" is
+optional, defaulting to now, in milliseconds since EPOCH, RFC3339, a UTC date
+or relative to now (ex: "-2h").`
+
+type btWriteRecord struct {
+	Key       string                     `json:"key"`
+	Timestamp string                     `json:"ts"`
+	Set       map[string]json.RawMessage `json:"set"`
+	Delete    []string                   `json:"delete"`
+	DeleteRow bool                       `json:"delete_row"`
+}
+
+func btWrite(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	if err := btCheckDestructiveAllowed(project, instance, "write rows to "+args[0]); err != nil {
+		return err
+	}
+
+	var protocol pbbstream.Protocol
+	if flagProtocol := protocolOrDefault(viper.GetString("bt-write-cmd-protocol")); flagProtocol != "" {
+		protocol = pbbstream.Protocol(pbbstream.Protocol_value[flagProtocol])
+		if protocol == pbbstream.Protocol_UNKNOWN {
+			return fmt.Errorf("invalid block --protocol value: %q", flagProtocol)
+		}
+	}
+
+	var reader io.Reader = os.Stdin
+	if len(args) > 1 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	table := client.Open(args[0])
+	batchSize := viper.GetInt("bt-write-cmd-batch-size")
+
+	var keys []string
+	var muts []*bigtable.Mutation
+	written := 0
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+
+		errs, err := table.ApplyBulk(ctx, keys, muts)
+		if err != nil {
+			return fmt.Errorf("apply bulk: %s", err)
+		}
+		for i, rowErr := range errs {
+			if rowErr != nil {
+				return fmt.Errorf("apply bulk: row %q: %s", keys[i], rowErr)
+			}
+		}
+
+		written += len(keys)
+		keys, muts = nil, nil
+		return nil
+	}
+
+	decoder := json.NewDecoder(reader)
+	for line := 1; ; line++ {
+		var record btWriteRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record #%d: %s", line, err)
+		}
+
+		mut, err := btMutationFromRecord(protocol, &record)
+		if err != nil {
+			return fmt.Errorf("record #%d (%q): %s", line, record.Key, err)
+		}
+
+		keys = append(keys, record.Key)
+		muts = append(muts, mut)
+		if len(keys) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d rows to %s:%s/%s\n", written, project, instance, args[0])
+	return nil
+}
+
+func btMutationFromRecord(protocol pbbstream.Protocol, record *btWriteRecord) (*bigtable.Mutation, error) {
+	if record.Key == "" {
+		return nil, fmt.Errorf("missing key")
+	}
+
+	ts := bigtable.Now()
+	if record.Timestamp != "" {
+		var err error
+		if ts, err = msToBTTimestamp(record.Timestamp); err != nil {
+			return nil, err
+		}
+	}
+
+	mut := bigtable.NewMutation()
+	if record.DeleteRow {
+		mut.DeleteRow()
+	}
+
+	for _, column := range record.Delete {
+		parts := strings.SplitN(column, ":", 2)
+		if len(parts) == 1 {
+			mut.DeleteCellsInFamily(parts[0])
+		} else {
+			mut.DeleteCellsInColumn(parts[0], parts[1])
+		}
+	}
+
+	for column, rawValue := range record.Set {
+		parts := strings.SplitN(column, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid column %q, expected 'family:qualifier'", column)
+		}
+
+		value, err := btEncodeCellValue(protocol, column, rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %s", column, err)
+		}
+		mut.Set(parts[0], parts[1], ts, value)
+	}
+
+	return mut, nil
+}
+
+// btEncodeCellValue is the inverse of what `btRead` does: JSON strings are
+// taken as is, objects are encoded with the proto type of the column.
+func btEncodeCellValue(protocol pbbstream.Protocol, column string, rawValue json.RawMessage) ([]byte, error) {
+	trimmed := bytes.TrimSpace(rawValue)
+	if len(trimmed) > 0 && trimmed[0] == '"' {
+		var str string
+		if err := json.Unmarshal(trimmed, &str); err != nil {
+			return nil, err
+		}
+		return []byte(str), nil
+	}
+
+	key := strings.Replace(column, "-", "_", -1)
+	key = strings.Replace(key, ":", "_", -1)
+	protoMessage := getProtoMap(protocol, key)
+	if protoMessage == nil {
+		return nil, fmt.Errorf("no known protobuf type for this column with protocol %s, use a JSON string for raw bytes", protocol)
+	}
+
+	if err := jsonpb.Unmarshal(bytes.NewReader(trimmed), protoMessage); err != nil {
+		return nil, fmt.Errorf("json to %T: %s", protoMessage, err)
+	}
+
+	return proto.Marshal(protoMessage)
+}
+
+func btDelete(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	dryRun := viper.GetBool("bt-delete-cmd-dry-run")
+	if !dryRun {
+		if err := btCheckDestructiveAllowed(project, instance, "delete rows from "+args[0]); err != nil {
+			return err
+		}
+	}
+
+	if btRowSelectionIsUnbounded("bt-delete-cmd") {
+		return fmt.Errorf("refusing to delete the whole table, use --prefix, --start/--end, --key or a typed key flag")
+	}
+
+	rowSet, opts, err := btRowSelection("bt-delete-cmd", args[0], bigtable.StripValueFilter())
+	if err != nil {
+		return err
+	}
+
+	cells, err := btDeleteCellSelection("bt-delete-cmd", args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	table := client.Open(args[0])
+
+	var keys []string
+	var muts []*bigtable.Mutation
+	count := 0
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+
+		errs, err := table.ApplyBulk(ctx, keys, muts)
+		if err != nil {
+			return fmt.Errorf("apply bulk: %s", err)
+		}
+		for i, rowErr := range errs {
+			if rowErr != nil {
+				return fmt.Errorf("apply bulk: row %q: %s", keys[i], rowErr)
+			}
+		}
+
+		keys, muts = nil, nil
+		return nil
+	}
+
+	var innerError error
+	err = table.ReadRows(ctx, rowSet, func(row bigtable.Row) bool {
+		count++
+		if dryRun {
+			return true
+		}
+
+		keys = append(keys, row.Key())
+		muts = append(muts, cells.mutation(row))
+		if len(keys) >= 1000 {
+			innerError = flush()
+		}
+		return innerError == nil
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	if innerError != nil {
+		return innerError
+	}
+
+	if dryRun {
+		fmt.Printf("Would delete %s%d rows from %s:%s/%s\n", cells.what(), count, project, instance, args[0])
+		return nil
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %s%d rows from %s:%s/%s\n", cells.what(), count, project, instance, args[0])
+	return nil
+}
+
+// btDeleteCells is what `bt delete` removes out of each selected row: the
+// whole row, or only the cells selected by `--family`, `--column` and
+// `--ts-start/--ts-end`.
+type btDeleteCells struct {
+	wholeRows bool
+	start     bigtable.Timestamp
+	end       bigtable.Timestamp
+}
+
+func btDeleteCellSelection(cmdKey, table string) (*btDeleteCells, error) {
+	start, err := btTimestampFlag(cmdKey, "ts-start", table)
+	if err != nil {
+		return nil, err
+	}
+	end, err := btTimestampFlag(cmdKey, "ts-end", table)
+	if err != nil {
+		return nil, err
+	}
+
+	wholeRows := viper.GetString(cmdKey+"-family") == "" && viper.GetString(cmdKey+"-column") == "" && start == 0 && end == 0
+	return &btDeleteCells{wholeRows: wholeRows, start: start, end: end}, nil
+}
+
+func (c *btDeleteCells) what() string {
+	if c.wholeRows {
+		return ""
+	}
+	return "the selected cells of "
+}
+
+// mutation deletes the columns of `row`, as read through the selection
+// filters, only within the time range when there's one.
+func (c *btDeleteCells) mutation(row bigtable.Row) *bigtable.Mutation {
+	mut := bigtable.NewMutation()
+	if c.wholeRows {
+		mut.DeleteRow()
+		return mut
+	}
+
+	seen := map[string]bool{}
+	for family, items := range row {
+		for _, item := range items {
+			if seen[item.Column] {
+				continue
+			}
+			seen[item.Column] = true
+
+			column := strings.TrimPrefix(item.Column, family+":")
+			if c.start != 0 || c.end != 0 {
+				mut.DeleteTimestampRange(family, column, c.start, c.end)
+			} else {
+				mut.DeleteCellsInColumn(family, column)
+			}
+		}
+	}
+	return mut
+}
+
+func btRowSelectionIsUnbounded(cmdKey string) bool {
+	for _, flag := range []string{"prefix", "key"} {
+		if len(viper.GetStringSlice(cmdKey+"-"+flag)) != 0 {
+			return false
+		}
+	}
+	for _, flag := range []string{"start", "end", "trx-id", "block-num", "block-id", "account"} {
+		if viper.GetString(cmdKey+"-"+flag) != "" {
+			return false
+		}
+	}
+	return true
+}
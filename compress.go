@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/dfuse-io/dbin"
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/dfuse-io/kvdb/store"
+	"github.com/dustin/go-humanize"
+	"github.com/golang/protobuf/proto"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	zstd2 "github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const compressInputHelp = `Values are read from any combination of inputs:
+
+    dbin files          passed as arguments, each block is one value
+    --bt-table          cells of a big table table, grouped by column family
+                        or column (--group-by), with the same row selection
+                        flags as 'bt read'
+    --kv-store          values of a kv store, grouped by their first
+                        --kv-group-bytes key bytes
+
+--limit applies to each input, in rows, blocks or keys.`
+
+var compressCmd = &cobra.Command{Use: "compress", Short: "compression benchmarks"}
+var compressTestCmd = &cobra.Command{Use: "test [dbin-file]...", Short: "compare compression codecs and levels on values of dbin files, a kv store or a big table table", Long: compressInputHelp, RunE: compressTest}
+
+func init() {
+	rootCmd.AddCommand(compressCmd)
+	compressCmd.AddCommand(compressTestCmd)
+
+	addCompressionInputFlags(compressTestCmd, "family")
+	addCompressionBenchFlags(compressTestCmd)
+	compressTestCmd.Flags().IntP("limit", "l", 1000, "limit the number of rows, blocks or keys read from each input")
+}
+
+func addCompressionInputFlags(cmd *cobra.Command, groupBy string) {
+	addBTRowSelectionFlags(cmd)
+	cmd.Flags().String("bt-table", "", "read cells of this big table table")
+	cmd.Flags().String("group-by", groupBy, "group big table cells by 'family' or 'column'")
+	cmd.Flags().String("db", "", "bigtable project:instance or profile name, for --bt-table, defaults to the --profile one")
+	cmd.Flags().String("kv-store", "", "read values of this kv store DSN")
+	cmd.Flags().String("kv-prefix", "", "only read --kv-store keys with this key expression prefix")
+	cmd.Flags().Int("kv-group-bytes", 1, "group --kv-store values by this many leading key bytes")
+}
+
+func addCompressionBenchFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("protocol", "p", "", "block protocol of the cells, to also time their protobuf unmarshaling")
+	cmd.Flags().StringSlice("codecs", nil, "codecs to compare (default all): "+strings.Join(compressionCodecNames, ", "))
+	cmd.Flags().StringSlice("zstd-dict", nil, "zstd dictionary file for the zstd-dict codec, as 'file' for all groups or 'group=file' (repeatable)")
+	cmd.Flags().Int("min-size", 0, "values smaller than this are stored uncompressed by every codec")
+}
+
+func compressTest(cmd *cobra.Command, args []string) (err error) {
+	inputs, err := compressionInputs("compress-test-cmd", args)
+	if err != nil {
+		return err
+	}
+
+	return runCompressionBench("compress-test-cmd", inputs)
+}
+
+func btTestCompression(cmd *cobra.Command, args []string) (err error) {
+	project, instance, err := splitDb()
+	if err != nil {
+		return err
+	}
+
+	input, err := btCompressionInput("bt-test-compression-cmd", project, instance, args[0])
+	if err != nil {
+		return err
+	}
+
+	return runCompressionBench("bt-test-compression-cmd", []*compressionInput{input})
+}
+
+type compressionValue struct {
+	group string
+	value []byte
+
+	// message, when set, is the type the value is unmarshaled to, timing protobuf decoding
+	message proto.Message
+}
+
+type compressionInput struct {
+	name string
+	unit string
+
+	// read feeds every value to `onValue`, returning the number of units (rows, blocks, keys) read
+	read func(onValue func(compressionValue) error) (int, error)
+}
+
+func compressionInputs(cmdKey string, files []string) (inputs []*compressionInput, err error) {
+	limit := viper.GetInt(cmdKey + "-limit")
+
+	for _, file := range files {
+		inputs = append(inputs, dbinCompressionInput(file, limit))
+	}
+
+	if table := viper.GetString(cmdKey + "-bt-table"); table != "" {
+		project, instance, err := resolveDb(viper.GetString(cmdKey + "-db"))
+		if err != nil {
+			return nil, err
+		}
+
+		input, err := btCompressionInput(cmdKey, project, instance, table)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+
+	if dsn := viper.GetString(cmdKey + "-kv-store"); dsn != "" {
+		prefix, err := parseKeyExpr(viper.GetString(cmdKey + "-kv-prefix"))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding --kv-prefix: %s", err)
+		}
+		inputs = append(inputs, kvCompressionInput(dsn, prefix, viper.GetInt(cmdKey+"-kv-group-bytes"), limit))
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no input, pass dbin files, --bt-table or --kv-store")
+	}
+	return inputs, nil
+}
+
+func btCompressionInput(cmdKey, project, instance, table string) (*compressionInput, error) {
+	if err := btConfirmScan(project, instance, cmdKey, table); err != nil {
+		return nil, err
+	}
+
+	var protocol pbbstream.Protocol
+	if flagProtocol := protocolOrDefault(viper.GetString(cmdKey + "-protocol")); flagProtocol != "" {
+		protocol = pbbstream.Protocol(pbbstream.Protocol_value[flagProtocol])
+		if protocol == pbbstream.Protocol_UNKNOWN {
+			return nil, fmt.Errorf("invalid block --protocol value: %q", flagProtocol)
+		}
+	}
+
+	groupBy := viper.GetString(cmdKey + "-group-by")
+	if groupBy != "family" && groupBy != "column" {
+		return nil, fmt.Errorf("invalid --group-by value %q, expected 'family' or 'column'", groupBy)
+	}
+
+	rowSet, opts, err := btRowSelection(cmdKey, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressionInput{
+		name: fmt.Sprintf("table %s:%s/%s", project, instance, table),
+		unit: "rows",
+		read: func(onValue func(compressionValue) error) (int, error) {
+			client, err := newBigTableClient(project, instance)
+			if err != nil {
+				return 0, err
+			}
+			defer client.Close()
+
+			rows := 0
+			var innerError error
+			err = client.Open(table).ReadRows(context.Background(), rowSet, func(row bigtable.Row) bool {
+				rows++
+				for family, items := range row {
+					for _, item := range items {
+						key := strings.Replace(item.Column, "-", "_", -1)
+						key = strings.Replace(key, ":", "_", -1)
+
+						group := family
+						if groupBy == "column" {
+							group = item.Column
+						}
+
+						innerError = onValue(compressionValue{group: group, value: item.Value, message: getProtoMap(protocol, key)})
+						if innerError != nil {
+							return false
+						}
+					}
+				}
+				return true
+			}, opts...)
+			if err != nil {
+				return rows, err
+			}
+			return rows, innerError
+		},
+	}, nil
+}
+
+func dbinCompressionInput(file string, limit int) *compressionInput {
+	return &compressionInput{
+		name: "dbin file " + file,
+		unit: "blocks",
+		read: func(onValue func(compressionValue) error) (int, error) {
+			f, err := os.Open(file)
+			if err != nil {
+				return 0, err
+			}
+			defer f.Close()
+
+			binReader := dbin.NewReader(f)
+			contentType, _, err := binReader.ReadHeader()
+			if err != nil {
+				return 0, fmt.Errorf("reading dbin header: %s", err)
+			}
+
+			blocks := 0
+			for limit == 0 || blocks < limit {
+				msg, err := binReader.ReadMessage()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return blocks, fmt.Errorf("error reading message: %s", err)
+				}
+
+				blocks++
+				if err := onValue(compressionValue{group: "dbin:" + contentType, value: msg, message: &pbbstream.Block{}}); err != nil {
+					return blocks, err
+				}
+			}
+			return blocks, nil
+		},
+	}
+}
+
+func kvCompressionInput(dsn string, prefix []byte, groupBytes, limit int) *compressionInput {
+	return &compressionInput{
+		name: "kv store " + dsn,
+		unit: "keys",
+		read: func(onValue func(compressionValue) error) (int, error) {
+			kv, err := store.New(dsn)
+			if err != nil {
+				return 0, err
+			}
+			defer closeKVStore(kv)
+
+			it := kv.Prefix(context.Background(), prefix)
+			defer it.Close()
+
+			keys := 0
+			for (limit == 0 || keys < limit) && it.Next() {
+				item := it.Item()
+				group := item.Key
+				if len(group) > groupBytes {
+					group = group[:groupBytes]
+				}
+
+				keys++
+				if err := onValue(compressionValue{group: "kv:" + formatKeyExpr(group), value: item.Value}); err != nil {
+					return keys, err
+				}
+			}
+			return keys, it.Err()
+		},
+	}
+}
+
+func runCompressionBench(cmdKey string, inputs []*compressionInput) error {
+	dicts, err := loadZstdDicts(viper.GetStringSlice(cmdKey + "-zstd-dict"))
+	if err != nil {
+		return err
+	}
+
+	codecs, err := newCompressionCodecs(viper.GetStringSlice(cmdKey+"-codecs"), dicts)
+	if err != nil {
+		return err
+	}
+
+	bench := newCompressionBench(codecs, viper.GetInt(cmdKey+"-min-size"))
+
+	t0 := time.Now()
+	for _, input := range inputs {
+		count, err := input.read(bench.add)
+		if err != nil {
+			return fmt.Errorf("%s: %s", input.name, err)
+		}
+		fmt.Printf("Read %s %s from %s\n", humanize.Comma(int64(count)), input.unit, input.name)
+	}
+
+	fmt.Printf("Processed %s values (%s) in %s, compressing and decompressing each with every codec\n", humanize.Comma(int64(bench.total.values)), humanize.Bytes(uint64(bench.total.rawBytes)), time.Since(t0).Round(time.Millisecond))
+	fmt.Println("")
+
+	bench.print(os.Stdout)
+	return nil
+}
+
+// loadZstdDicts reads `file` or `group=file` specs, the former being the
+// dictionary of all groups without their own, keyed by the empty group.
+func loadZstdDicts(specs []string) (map[string][]byte, error) {
+	dicts := map[string][]byte{}
+	for _, spec := range specs {
+		group, file := "", spec
+		if idx := strings.Index(spec, "="); idx != -1 {
+			group, file = spec[:idx], spec[idx+1:]
+		}
+
+		dict, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd dictionary: %s", err)
+		}
+		dicts[group] = dict
+	}
+	return dicts, nil
+}
+
+var compressionCodecNames = []string{"zstd-fastest", "zstd-default", "zstd-better", "zstd-best", "zstd-dict", "gzip", "snappy"}
+
+type compressionCodec struct {
+	name       string
+	compress   func(group string, src []byte) ([]byte, error)
+	decompress func(group string, src []byte) ([]byte, error)
+}
+
+// newCompressionCodecs builds the codecs named in `names`, or all of them
+// when empty, `zstd-dict` being only included by default with `dicts`.
+func newCompressionCodecs(names []string, dicts map[string][]byte) (out []*compressionCodec, err error) {
+	if len(names) == 0 {
+		for _, name := range compressionCodecNames {
+			if name != "zstd-dict" || len(dicts) != 0 {
+				names = append(names, name)
+			}
+		}
+	}
+
+	zstdDecoder, err := zstd2.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	zstdDecompress := func(group string, src []byte) ([]byte, error) { return zstdDecoder.DecodeAll(src, nil) }
+
+	for _, name := range names {
+		switch name {
+		case "zstd-fastest", "zstd-default", "zstd-better", "zstd-best":
+			_, level := zstd2.EncoderLevelFromString(strings.TrimPrefix(name, "zstd-"))
+			encoder, err := zstd2.NewWriter(nil, zstd2.WithEncoderLevel(level))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, &compressionCodec{
+				name:       name,
+				compress:   func(group string, src []byte) ([]byte, error) { return encoder.EncodeAll(src, nil), nil },
+				decompress: zstdDecompress,
+			})
+
+		case "zstd-dict":
+			if len(dicts) == 0 {
+				return nil, fmt.Errorf("codec zstd-dict needs a dictionary, use --zstd-dict")
+			}
+			codec, err := newZstdDictCodec(dicts)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, codec)
+
+		case "gzip":
+			out = append(out, &compressionCodec{
+				name: name,
+				compress: func(group string, src []byte) ([]byte, error) {
+					buf := &bytes.Buffer{}
+					writer := gzip.NewWriter(buf)
+					if _, err := writer.Write(src); err != nil {
+						return nil, err
+					}
+					if err := writer.Close(); err != nil {
+						return nil, err
+					}
+					return buf.Bytes(), nil
+				},
+				decompress: func(group string, src []byte) ([]byte, error) {
+					reader, err := gzip.NewReader(bytes.NewReader(src))
+					if err != nil {
+						return nil, err
+					}
+					return ioutil.ReadAll(reader)
+				},
+			})
+
+		case "snappy":
+			out = append(out, &compressionCodec{
+				name:       name,
+				compress:   func(group string, src []byte) ([]byte, error) { return snappy.Encode(nil, src), nil },
+				decompress: func(group string, src []byte) ([]byte, error) { return snappy.Decode(nil, src) },
+			})
+
+		default:
+			return nil, fmt.Errorf("unknown codec %q, known codecs: %s", name, strings.Join(compressionCodecNames, ", "))
+		}
+	}
+
+	return out, nil
+}
+
+// newZstdDictCodec compresses each group with its own dictionary, falling
+// back to the one of the empty group, or to no dictionary at all.
+func newZstdDictCodec(dicts map[string][]byte) (*compressionCodec, error) {
+	encoders := map[string]*zstd2.Encoder{}
+	decoders := map[string]*zstd2.Decoder{}
+	for group, dict := range dicts {
+		encoder, err := zstd2.NewWriter(nil, zstd2.WithEncoderDict(dict))
+		if err != nil {
+			return nil, fmt.Errorf("zstd dictionary %q: %s", group, err)
+		}
+		decoder, err := zstd2.NewReader(nil, zstd2.WithDecoderDicts(dict))
+		if err != nil {
+			return nil, fmt.Errorf("zstd dictionary %q: %s", group, err)
+		}
+		encoders[group], decoders[group] = encoder, decoder
+	}
+
+	noDictEncoder, err := zstd2.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	noDictDecoder, err := zstd2.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressionCodec{
+		name: "zstd-dict",
+		compress: func(group string, src []byte) ([]byte, error) {
+			encoder := encoders[group]
+			if encoder == nil {
+				encoder = encoders[""]
+			}
+			if encoder == nil {
+				encoder = noDictEncoder
+			}
+			return encoder.EncodeAll(src, nil), nil
+		},
+		decompress: func(group string, src []byte) ([]byte, error) {
+			decoder := decoders[group]
+			if decoder == nil {
+				decoder = decoders[""]
+			}
+			if decoder == nil {
+				decoder = noDictDecoder
+			}
+			return decoder.DecodeAll(src, nil)
+		},
+	}, nil
+}
+
+type compressionBench struct {
+	codecs  []*compressionCodec
+	minSize int
+	groups  map[string]*compressionGroupStats
+	total   *compressionGroupStats
+}
+
+type compressionGroupStats struct {
+	values         int
+	rawBytes       int
+	unmarshalBytes int
+	unmarshalTime  time.Duration
+	codecs         []compressionCodecStats
+}
+
+type compressionCodecStats struct {
+	compressedBytes int
+	compressTime    time.Duration
+	decompressTime  time.Duration
+}
+
+func newCompressionBench(codecs []*compressionCodec, minSize int) *compressionBench {
+	return &compressionBench{
+		codecs:  codecs,
+		minSize: minSize,
+		groups:  map[string]*compressionGroupStats{},
+		total:   &compressionGroupStats{codecs: make([]compressionCodecStats, len(codecs))},
+	}
+}
+
+func (b *compressionBench) add(v compressionValue) error {
+	stats := b.groups[v.group]
+	if stats == nil {
+		stats = &compressionGroupStats{codecs: make([]compressionCodecStats, len(b.codecs))}
+		b.groups[v.group] = stats
+	}
+
+	var codecStats []compressionCodecStats
+	for _, codec := range b.codecs {
+		if len(v.value) < b.minSize {
+			codecStats = append(codecStats, compressionCodecStats{compressedBytes: len(v.value)})
+			continue
+		}
+
+		t0 := time.Now()
+		compressed, err := codec.compress(v.group, v.value)
+		compressTime := time.Since(t0)
+		if err != nil {
+			return fmt.Errorf("%s: compressing %s value: %s", codec.name, v.group, err)
+		}
+
+		t1 := time.Now()
+		decompressed, err := codec.decompress(v.group, compressed)
+		decompressTime := time.Since(t1)
+		if err != nil {
+			return fmt.Errorf("%s: decompressing %s value: %s", codec.name, v.group, err)
+		}
+		if !bytes.Equal(decompressed, v.value) {
+			return fmt.Errorf("%s: %s value of %d bytes decompressed to different %d bytes", codec.name, v.group, len(v.value), len(decompressed))
+		}
+
+		codecStats = append(codecStats, compressionCodecStats{
+			compressedBytes: len(compressed),
+			compressTime:    compressTime,
+			decompressTime:  decompressTime,
+		})
+	}
+
+	var unmarshalTime time.Duration
+	if v.message != nil {
+		t0 := time.Now()
+		if err := proto.Unmarshal(v.value, v.message); err != nil {
+			return fmt.Errorf("proto unmarshal %s value to %T: %s", v.group, v.message, err)
+		}
+		unmarshalTime = time.Since(t0)
+	}
+
+	for _, s := range []*compressionGroupStats{stats, b.total} {
+		s.values++
+		s.rawBytes += len(v.value)
+		if v.message != nil {
+			s.unmarshalBytes += len(v.value)
+			s.unmarshalTime += unmarshalTime
+		}
+		for i, codecStat := range codecStats {
+			s.codecs[i].compressedBytes += codecStat.compressedBytes
+			s.codecs[i].compressTime += codecStat.compressTime
+			s.codecs[i].decompressTime += codecStat.decompressTime
+		}
+	}
+
+	return nil
+}
+
+func (b *compressionBench) sortedGroups() (out []string) {
+	for group := range b.groups {
+		out = append(out, group)
+	}
+	sort.Strings(out)
+	return
+}
+
+func (b *compressionBench) print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tCODEC\tVALUES\tRAW\tCOMPRESSED\tRATIO\tCOMPRESS\tDECOMPRESS\t")
+
+	printGroup := func(name string, stats *compressionGroupStats) {
+		for i, codec := range b.codecs {
+			codecStats := stats.codecs[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+				name,
+				codec.name,
+				humanize.Comma(int64(stats.values)),
+				humanize.Bytes(uint64(stats.rawBytes)),
+				humanize.Bytes(uint64(codecStats.compressedBytes)),
+				compressionRatio(codecStats.compressedBytes, stats.rawBytes),
+				throughput(stats.rawBytes, codecStats.compressTime),
+				throughput(stats.rawBytes, codecStats.decompressTime),
+			)
+		}
+	}
+
+	for _, group := range b.sortedGroups() {
+		printGroup(group, b.groups[group])
+	}
+	if len(b.groups) > 1 {
+		printGroup("(total)", b.total)
+	}
+	w.Flush()
+
+	if b.total.unmarshalTime == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tPROTOBUF UNMARSHAL\t")
+	for _, group := range b.sortedGroups() {
+		stats := b.groups[group]
+		if stats.unmarshalTime != 0 {
+			fmt.Fprintf(w, "%s\t%s\t\n", group, throughput(stats.unmarshalBytes, stats.unmarshalTime))
+		}
+	}
+	w.Flush()
+}
+
+// compressionRatio is the compressed size, in percent of the raw size
+func compressionRatio(compressed, raw int) string {
+	if raw == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(compressed)/float64(raw)*100.0)
+}
+
+func throughput(bytes int, elapsed time.Duration) string {
+	if elapsed == 0 {
+		return "-"
+	}
+	return humanize.Bytes(uint64(float64(bytes)/elapsed.Seconds())) + "/s"
+}
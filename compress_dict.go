@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+	"github.com/klauspost/compress/dict"
+	zstd2 "github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var compressTrainDictCmd = &cobra.Command{Use: "train-dict [dbin-file]...", Short: "train a zstd dictionary per column out of sampled values, and report its gain", Long: compressInputHelp, RunE: compressTrainDict}
+
+func init() {
+	compressCmd.AddCommand(compressTrainDictCmd)
+
+	addCompressionInputFlags(compressTrainDictCmd, "column")
+	compressTrainDictCmd.Flags().IntP("limit", "l", 10000, "limit the number of rows, blocks or keys read from each input")
+	compressTrainDictCmd.Flags().StringP("output-dir", "o", ".", "directory where the dictionaries are written, as [group].dict")
+	compressTrainDictCmd.Flags().Int("dict-size", 64*1024, "maximum size of each dictionary, in bytes")
+	compressTrainDictCmd.Flags().Int("holdout-every", 10, "keep every Nth value of each group out of training, to measure the gain on unseen values (0 measures on the training values)")
+	compressTrainDictCmd.Flags().Int("min-samples", 20, "skip groups with fewer training values than this")
+}
+
+func compressTrainDict(cmd *cobra.Command, args []string) (err error) {
+	inputs, err := compressionInputs("compress-train-dict-cmd", args)
+	if err != nil {
+		return err
+	}
+
+	holdoutEvery := viper.GetInt("compress-train-dict-cmd-holdout-every")
+	samples := map[string][][]byte{}
+	holdouts := map[string][][]byte{}
+	seen := map[string]int{}
+
+	for _, input := range inputs {
+		count, err := input.read(func(v compressionValue) error {
+			// Some inputs reuse their buffers between values
+			value := append([]byte(nil), v.value...)
+
+			seen[v.group]++
+			if holdoutEvery > 0 && seen[v.group]%holdoutEvery == 0 {
+				holdouts[v.group] = append(holdouts[v.group], value)
+				return nil
+			}
+			samples[v.group] = append(samples[v.group], value)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %s", input.name, err)
+		}
+		fmt.Printf("Read %s %s from %s\n", humanize.Comma(int64(count)), input.unit, input.name)
+	}
+
+	var groups []string
+	for group := range samples {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	outputDir := viper.GetString("compress-train-dict-cmd-output-dir")
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+
+	minSamples := viper.GetInt("compress-train-dict-cmd-min-samples")
+	dicts := map[string][]byte{}
+	var dictFlags []string
+	for _, group := range groups {
+		groupSamples := samples[group]
+		if len(groupSamples) < minSamples {
+			fmt.Printf("Skipping %s: only %d samples\n", group, len(groupSamples))
+			continue
+		}
+
+		zstdDict, err := dict.BuildZstdDict(groupSamples, dict.Options{
+			MaxDictSize: viper.GetInt("compress-train-dict-cmd-dict-size"),
+			HashBytes:   6,
+			ZstdLevel:   zstd2.SpeedDefault,
+		})
+		if err != nil {
+			fmt.Printf("Skipping %s: training failed: %s\n", group, err)
+			continue
+		}
+
+		file := filepath.Join(outputDir, dictFileName(group))
+		if err := ioutil.WriteFile(file, zstdDict, 0644); err != nil {
+			return err
+		}
+
+		dicts[group] = zstdDict
+		dictFlags = append(dictFlags, fmt.Sprintf("--zstd-dict '%s=%s'", group, file))
+		fmt.Printf("Trained %s dictionary for %s on %s values: %s\n", humanize.Bytes(uint64(len(zstdDict))), group, humanize.Comma(int64(len(groupSamples))), file)
+	}
+
+	if len(dicts) == 0 {
+		return fmt.Errorf("no dictionary could be trained, read more values with --limit")
+	}
+
+	codecs, err := newCompressionCodecs([]string{"zstd-default", "zstd-dict"}, dicts)
+	if err != nil {
+		return err
+	}
+
+	measured := holdouts
+	if holdoutEvery <= 0 {
+		measured = samples
+	}
+
+	bench := newCompressionBench(codecs, 0)
+	for group := range dicts {
+		for _, value := range measured[group] {
+			if err := bench.add(compressionValue{group: group, value: value}); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Println("")
+	if bench.total.values == 0 {
+		fmt.Println("No held out values to measure the gain on, read more values with --limit")
+	} else {
+		bench.print(os.Stdout)
+	}
+
+	fmt.Println("")
+	fmt.Println("Use them with (and --group-by column for big table cells):")
+	for _, flag := range dictFlags {
+		fmt.Println("   ", flag)
+	}
+	return nil
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func dictFileName(group string) string {
+	return unsafeFileNameChars.ReplaceAllString(group, "_") + ".dict"
+}
@@ -8,8 +8,10 @@ import (
 	"github.com/dfuse-io/dbin" // internal model, until we switch it all to Protobuf
 	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
 	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	pbdeth "github.com/dfuse-io/doh/pb/dfuse/codecs/deth"
 	"github.com/dfuse-io/jsonpb"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/tidwall/sjson"
@@ -21,6 +23,10 @@ func init() {
 	rootCmd.AddCommand(dbinCmd)
 
 	dbinCmd.Flags().IntP("depth", "d", 1, "Depth of decoding. 0 = top-level block, 1 = kind-specific blocks, 2 = future!")
+	dbinCmd.Flags().String("ts-start", "", "only keep blocks at or after this time: "+timeExprHelp)
+	dbinCmd.Flags().String("ts-end", "", "only keep blocks before this time: "+timeExprHelp)
+	addTreeFlag(dbinCmd)
+	addETHABIFlags(dbinCmd)
 }
 
 func viewDbin(cmd *cobra.Command, args []string) (err error) {
@@ -47,6 +53,11 @@ func viewDbin(cmd *cobra.Command, args []string) (err error) {
 		return fmt.Errorf("unsupported dbin content type: %s", contentType)
 	}
 
+	blockFilter, err := newBlockRangeFilter("dbin-cmd", pbbstream.Protocol(pbbstream.Protocol_value[contentType]))
+	if err != nil {
+		return err
+	}
+
 	depth := viper.GetInt("dbin-cmd-depth")
 	pbmarsh := jsonpb.Marshaler{
 		EnumsAsInts:  false,
@@ -54,6 +65,22 @@ func viewDbin(cmd *cobra.Command, args []string) (err error) {
 		OrigName:     true,
 	}
 
+	tree, err := treeMode("dbin-cmd")
+	if err != nil {
+		return err
+	}
+	if err := loadETHABIs("dbin-cmd"); err != nil {
+		return err
+	}
+	if tree != "" {
+		return viewDbinTrees(binReader, blockFilter, tree, pbbstream.Protocol(pbbstream.Protocol_value[contentType]))
+	}
+
+	output, err := newRecordWriter(dbinBlocksOutputSpec)
+	if err != nil {
+		return err
+	}
+
 	for {
 		msg, err := binReader.ReadMessage()
 		if err == io.EOF {
@@ -63,15 +90,90 @@ func viewDbin(cmd *cobra.Command, args []string) (err error) {
 			return fmt.Errorf("error reading message: %s", err)
 		}
 
+		if !blockFilter.isEmpty() {
+			block := &pbbstream.Block{}
+			if err := proto.Unmarshal(msg, block); err != nil {
+				return fmt.Errorf("proto unmarshal: %s", err)
+			}
+
+			// Blocks without a timestamp only match block number bounds
+			blockTime, _ := ptypes.Timestamp(block.Timestamp)
+			if !blockFilter.keep(block.Number, blockTime) {
+				continue
+			}
+		}
+
 		out, err := decodeInDepth("", pbmarsh, depth, &pbbstream.Block{}, msg, "")
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(out)
+		if err := output.write(json.RawMessage(out)); err != nil {
+			return err
+		}
+	}
+
+	return output.close()
+}
+
+// viewDbinTrees renders the transaction traces of each block as trees
+func viewDbinTrees(binReader *dbin.Reader, blockFilter *blockRangeFilter, mode string, protocol pbbstream.Protocol) error {
+	output, err := newTreeRecordWriter(mode, protocol)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	for {
+		msg, err := binReader.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading message: %s", err)
+		}
+
+		block := &pbbstream.Block{}
+		if err := proto.Unmarshal(msg, block); err != nil {
+			return fmt.Errorf("proto unmarshal: %s", err)
+		}
+
+		blockTime, _ := ptypes.Timestamp(block.Timestamp)
+		if !blockFilter.keep(block.Number, blockTime) {
+			continue
+		}
+
+		var payload proto.Message
+		switch block.PayloadKind {
+		case pbbstream.Protocol_EOS:
+			payload = &pbdeos.Block{}
+		case pbbstream.Protocol_ETH:
+			payload = &pbdeth.Block{}
+		default:
+			return fmt.Errorf("block #%d: unsupported protocol: %s", block.Number, block.PayloadKind)
+		}
+		if err := proto.Unmarshal(block.PayloadBuffer, payload); err != nil {
+			return fmt.Errorf("block #%d: proto unmarshal: %s", block.Number, err)
+		}
+
+		if err := writeTraceTrees(mode, output, payload); err != nil {
+			return fmt.Errorf("block #%d: %s", block.Number, err)
+		}
+	}
+
+	return closeTreeRecordWriter(output)
+}
+
+// Blocks summary in `csv` and `table` output, payloads being too large
+var dbinBlocksOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "number", path: "number"},
+		{header: "id", path: "id"},
+		{header: "previous_id", path: "previous_id"},
+		{header: "timestamp", path: "timestamp"},
+		{header: "lib_num", path: "lib_num"},
+		{header: "payload_kind", path: "payload_kind"},
+	},
 }
 
 func decodeInDepth(inputJSON string, marshaler jsonpb.Marshaler, depth int, obj proto.Message, bytes []byte, replaceField string) (out string, err error) {
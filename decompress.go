@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	zstd2 "github.com/klauspost/compress/zstd"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// valueDecompressor recognizes zstd and gzip compressed values by their
+// magic bytes, zstd frames referencing a dictionary being decoded with the
+// matching `--zstd-dict` one.
+type valueDecompressor struct {
+	zstdDecoder *zstd2.Decoder
+}
+
+func newValueDecompressor(zstdDictSpecs []string) (*valueDecompressor, error) {
+	dicts, err := loadZstdDicts(zstdDictSpecs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The decoder picks the dictionary matching the ID found in each frame, groups are irrelevant here
+	var opts []zstd2.DOption
+	for _, dict := range dicts {
+		opts = append(opts, zstd2.WithDecoderDicts(dict))
+	}
+
+	decoder, err := zstd2.NewReader(nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("zstd decoder: %s", err)
+	}
+
+	return &valueDecompressor{zstdDecoder: decoder}, nil
+}
+
+// decompress returns the decompressed value along with the codec name, or
+// the value untouched and an empty codec when it isn't compressed.
+func (d *valueDecompressor) decompress(value []byte) ([]byte, string, error) {
+	switch {
+	case bytes.HasPrefix(value, zstdMagic):
+		out, err := d.zstdDecoder.DecodeAll(value, nil)
+		if err == zstd2.ErrUnknownDictionary {
+			return value, "", fmt.Errorf("zstd: compressed with a dictionary, pass it with --zstd-dict")
+		}
+		if err != nil {
+			return value, "", fmt.Errorf("zstd: %s", err)
+		}
+		return out, "zstd", nil
+
+	case bytes.HasPrefix(value, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return value, "", fmt.Errorf("gzip: %s", err)
+		}
+		out, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return value, "", fmt.Errorf("gzip: %s", err)
+		}
+		return out, "gzip", nil
+	}
+
+	return value, "", nil
+}
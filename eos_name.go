@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const eosNameCharset = ".12345abcdefghijklmnopqrstuvwxyz"
+
+// eosNameToUint64 encodes an EOS account/table/action name into its
+// `uint64` representation, the same way `eosio::name` does it.
+func eosNameToUint64(name string) (uint64, error) {
+	if len(name) > 13 {
+		return 0, fmt.Errorf("name %q is longer than 13 characters", name)
+	}
+
+	var value uint64
+	for i := 0; i <= 12; i++ {
+		var c uint64
+		if i < len(name) {
+			idx := strings.IndexByte(eosNameCharset, name[i])
+			if idx == -1 {
+				return 0, fmt.Errorf("name %q contains invalid character %q", name, name[i])
+			}
+			c = uint64(idx)
+		}
+
+		if i < 12 {
+			c &= 0x1f
+			c <<= 64 - 5*(uint(i)+1)
+		} else {
+			if c > 0x0f {
+				return 0, fmt.Errorf("name %q has an invalid 13th character %q", name, name[i])
+			}
+			c &= 0x0f
+		}
+
+		value |= c
+	}
+
+	return value, nil
+}
+
+// eosNameToString is the inverse of `eosNameToUint64`, trailing dots are
+// trimmed like `eosio::name::to_string` does.
+func eosNameToString(value uint64) string {
+	out := make([]byte, 13)
+
+	tmp := value
+	for i := 0; i <= 12; i++ {
+		mask := uint64(0x1f)
+		if i == 0 {
+			mask = 0x0f
+		}
+		out[12-i] = eosNameCharset[tmp&mask]
+		if i == 0 {
+			tmp >>= 4
+		} else {
+			tmp >>= 5
+		}
+	}
+
+	return strings.TrimRight(string(out), ".")
+}
+
+// isLikelyEOSName returns whether the value round-trips cleanly through
+// the name encoding and looks like something a human would have chosen.
+func isLikelyEOSName(value uint64) bool {
+	if value == 0 {
+		return false
+	}
+
+	name := eosNameToString(value)
+	if strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return false
+	}
+
+	back, err := eosNameToUint64(name)
+	return err == nil && back == value
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestEOSNameToUint64(t *testing.T) {
+	tests := []struct {
+		name        string
+		expected    uint64
+		expectedErr bool
+	}{
+		{"", 0, false},
+		{"eosio", 0x5530ea0000000000, false},
+		{"eosio.token", 0x5530ea033482a600, false},
+		{"a", 0x3000000000000000, false},
+		{"zzzzzzzzzzzzj", 0xffffffffffffffff, false},
+		{"1", 0x0800000000000000, false},
+		{"toolongname.1234", 0, true},
+		{"Upper", 0, true},
+		{"bad6", 0, true},
+		{"zzzzzzzzzzzzz", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := eosNameToUint64(test.name)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %#x", value)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if value != test.expected {
+				t.Errorf("expected %#x, got %#x", test.expected, value)
+			}
+		})
+	}
+}
+
+func TestEOSNameRoundTrip(t *testing.T) {
+	for _, name := range []string{"eosio", "eosio.token", "a", "bob", "eosio.msig", "zzzzzzzzzzzzj", "a.b.c", "1234512345123"} {
+		t.Run(name, func(t *testing.T) {
+			value, err := eosNameToUint64(name)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if back := eosNameToString(value); back != name {
+				t.Errorf("expected %q, got %q", name, back)
+			}
+		})
+	}
+}
+
+func TestIsLikelyEOSName(t *testing.T) {
+	tests := []struct {
+		value    uint64
+		expected bool
+	}{
+		{0x5530ea033482a600, true},
+		{0x5530ea0000000000, true},
+		{0, false},
+		{0x0000000000003039, false},
+		{0x0000000000000001, false},
+	}
+
+	for _, test := range tests {
+		if actual := isLikelyEOSName(test.value); actual != test.expected {
+			t.Errorf("%#x (%q): expected %t, got %t", test.value, eosNameToString(test.value), test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	"github.com/dustin/go-humanize"
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+const eosRAMHelp = `Sums the RAM deltas of the RAM ops of EOS transaction traces, out of a dbin
+file (zstd compressed or not) or a merged blocks store, grouped by:
+
+    payer      the account paying for the RAM
+    namespace  the kind of RAM (TABLE_ROW/ADD, DEFERRED_TRX/PUSH, ...)
+    contract   the receiver of the action the op happened in
+    trx        the transaction, along with its actions' account RAM deltas
+
+Each transaction's account RAM deltas are checked against the RAM ops of
+the same accounts (as payers). Mismatches are reported as "mismatch"
+records, RAM correction ops being shown next to them.
+
+Transaction and mismatch records are written as blocks are read, the
+payer, namespace and contract totals once all of them are. Transactions
+are not reported by default, add "trx" to --group-by to get them.`
+
+var eosCmd = &cobra.Command{Use: "eos", Short: "EOS specific tools"}
+var eosRAMCmd = &cobra.Command{Use: "ram <file|store>", Short: "RAM usage per payer, namespace, contract and transaction, checking account RAM deltas against RAM ops", Long: eosRAMHelp, Args: cobra.ExactArgs(1), RunE: eosRAM}
+
+var eosRAMGroups = []string{"payer", "namespace", "contract", "trx"}
+var eosRAMDefaultGroups = []string{"payer", "namespace", "contract"}
+
+func init() {
+	rootCmd.AddCommand(eosCmd)
+	eosCmd.AddCommand(eosRAMCmd)
+
+	eosRAMCmd.Flags().Int64("start-block", 0, "skip blocks before this one, merged blocks stores starting at its bundle")
+	eosRAMCmd.Flags().Int64("stop-block", 0, "stop before this block, 0 to read to the end")
+	eosRAMCmd.Flags().StringSlice("group-by", eosRAMDefaultGroups, "groups to report, among: "+strings.Join(eosRAMGroups, ", "))
+	eosRAMCmd.Flags().Bool("mismatches-only", false, "only report account RAM deltas mismatching RAM ops")
+}
+
+// eosRAMRecord is a group's RAM ops total. Transactions and mismatches also
+// have their block and account RAM deltas (of an account for mismatches).
+type eosRAMRecord struct {
+	Group            string `json:"group"`
+	Key              string `json:"key"`
+	BlockNum         uint64 `json:"block_num,omitempty"`
+	Delta            int64  `json:"delta"`
+	Ops              int    `json:"ops"`
+	AccountRAMDeltas *int64 `json:"account_ram_deltas,omitempty"`
+	Corrections      *int64 `json:"corrections,omitempty"`
+}
+
+func humanizeRAMDeltaColumn(value gjson.Result) string {
+	if !value.Exists() {
+		return ""
+	}
+
+	delta := value.Int()
+	switch {
+	case delta > 0:
+		return "+" + humanize.Bytes(uint64(delta))
+	case delta < 0:
+		return "-" + humanize.Bytes(uint64(-delta))
+	}
+	return "0 B"
+}
+
+var eosRAMOutputSpec = outputSpec{
+	defaultFormat: "table",
+	columns: []outputColumn{
+		{header: "group", path: "group"},
+		{header: "key", path: "key"},
+		{header: "block", path: "block_num"},
+		{header: "delta", path: "delta", format: humanizeRAMDeltaColumn},
+		{header: "ops", path: "ops"},
+		{header: "account deltas", path: "account_ram_deltas", format: humanizeRAMDeltaColumn},
+		{header: "corrections", path: "corrections", format: humanizeRAMDeltaColumn},
+	},
+}
+
+// eosRAMTotal is a running total of RAM ops
+type eosRAMTotal struct {
+	delta int64
+	ops   int
+}
+
+func (t *eosRAMTotal) add(delta int64) {
+	t.delta += delta
+	t.ops++
+}
+
+// eosRAMStats aggregates the RAM ops of transaction traces, per payer,
+// namespace and contract. Transactions and mismatches are only counted,
+// their records being handed back to be written right away.
+type eosRAMStats struct {
+	groups     map[string]map[string]*eosRAMTotal
+	blocks     int
+	trxCount   int
+	mismatches int
+	withTrxs   bool
+}
+
+func newEOSRAMStats(withTrxs bool) *eosRAMStats {
+	stats := &eosRAMStats{groups: map[string]map[string]*eosRAMTotal{}, withTrxs: withTrxs}
+	for _, group := range eosRAMDefaultGroups {
+		stats.groups[group] = map[string]*eosRAMTotal{}
+	}
+	return stats
+}
+
+func (s *eosRAMStats) total(group, key string) *eosRAMTotal {
+	total := s.groups[group][key]
+	if total == nil {
+		total = &eosRAMTotal{}
+		s.groups[group][key] = total
+	}
+	return total
+}
+
+// eosRAMNamespace is `NAMESPACE/ACTION`, or the older `Operation` of ops
+// without a namespace.
+func eosRAMNamespace(op *pbdeos.RAMOp) string {
+	if op.Namespace == pbdeos.RAMOp_NAMESPACE_UNKNOWN {
+		return trimEnumPrefix(op.Operation, "OPERATION_")
+	}
+	return trimEnumPrefix(op.Namespace, "NAMESPACE_") + "/" + trimEnumPrefix(op.Action, "ACTION_")
+}
+
+// addTrace adds the RAM ops of `trace` to the totals, returning its trx
+// record (when asked for) and its mismatch records.
+func (s *eosRAMStats) addTrace(blockNum uint64, trace *pbdeos.TransactionTrace) (out []*eosRAMRecord) {
+	trx := &eosRAMTotal{}
+	opsByPayer := map[string]int64{}
+	for _, op := range trace.RamOps {
+		contract := "(no action)"
+		if int(op.ActionIndex) < len(trace.ActionTraces) {
+			contract = trace.ActionTraces[op.ActionIndex].Receiver
+		}
+
+		s.total("payer", op.Payer).add(op.Delta)
+		s.total("namespace", eosRAMNamespace(op)).add(op.Delta)
+		s.total("contract", contract).add(op.Delta)
+		trx.add(op.Delta)
+		opsByPayer[op.Payer] += op.Delta
+	}
+
+	deltasByAccount := map[string]int64{}
+	var accountDeltas int64
+	for _, action := range trace.ActionTraces {
+		for _, delta := range action.AccountRamDeltas {
+			deltasByAccount[delta.Account] += delta.Delta
+			accountDeltas += delta.Delta
+		}
+	}
+
+	correctionsByPayer := map[string]int64{}
+	var corrections int64
+	for _, op := range trace.RamCorrectionOps {
+		correctionsByPayer[op.Payer] += op.Delta
+		corrections += op.Delta
+	}
+
+	s.trxCount++
+	if s.withTrxs {
+		out = append(out, &eosRAMRecord{
+			Group:            "trx",
+			Key:              trace.Id,
+			BlockNum:         blockNum,
+			Delta:            trx.delta,
+			Ops:              trx.ops,
+			AccountRAMDeltas: &accountDeltas,
+			Corrections:      &corrections,
+		})
+	}
+
+	accounts := map[string]bool{}
+	for account := range deltasByAccount {
+		accounts[account] = true
+	}
+	for payer := range opsByPayer {
+		accounts[payer] = true
+	}
+
+	var mismatched []string
+	for account := range accounts {
+		if deltasByAccount[account] != opsByPayer[account] {
+			mismatched = append(mismatched, account)
+		}
+	}
+	sort.Strings(mismatched)
+
+	for _, account := range mismatched {
+		accountDelta, correction := deltasByAccount[account], correctionsByPayer[account]
+		ops := 0
+		for _, op := range trace.RamOps {
+			if op.Payer == account {
+				ops++
+			}
+		}
+
+		s.mismatches++
+		out = append(out, &eosRAMRecord{
+			Group:            "mismatch",
+			Key:              trace.Id + ":" + account,
+			BlockNum:         blockNum,
+			Delta:            opsByPayer[account],
+			Ops:              ops,
+			AccountRAMDeltas: &accountDelta,
+			Corrections:      &correction,
+		})
+	}
+	return out
+}
+
+// records returns the totals of `group`, largest RAM consumers first
+func (s *eosRAMStats) records(group string) (out []*eosRAMRecord) {
+	for key, total := range s.groups[group] {
+		out = append(out, &eosRAMRecord{Group: group, Key: key, Delta: total.delta, Ops: total.ops})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Delta != out[j].Delta {
+			return out[i].Delta > out[j].Delta
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func eosRAM(cmd *cobra.Command, args []string) (err error) {
+	known := map[string]bool{}
+	for _, group := range eosRAMGroups {
+		known[group] = true
+	}
+
+	var groups []string
+	withTrxs := false
+	for _, group := range viper.GetStringSlice("eos-ram-cmd-group-by") {
+		if !known[group] {
+			return fmt.Errorf("invalid --group-by %q, expected some of: %s", group, strings.Join(eosRAMGroups, ", "))
+		}
+		if group == "trx" {
+			withTrxs = true
+			continue
+		}
+		groups = append(groups, group)
+	}
+	if viper.GetBool("eos-ram-cmd-mismatches-only") {
+		groups, withTrxs = nil, false
+	}
+
+	startBlock := uint64(viper.GetInt64("eos-ram-cmd-start-block"))
+	stopBlock := uint64(viper.GetInt64("eos-ram-cmd-stop-block"))
+
+	source, err := newBlockSource(args[0], startBlock)
+	if err != nil {
+		return err
+	}
+	defer source.close()
+
+	output, err := newRecordWriter(eosRAMOutputSpec)
+	if err != nil {
+		return err
+	}
+
+	stats := newEOSRAMStats(withTrxs)
+	for done := false; !done; {
+		batch, err := source.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, block := range batch {
+			if stopBlock != 0 && block.Number >= stopBlock {
+				done = true
+				break
+			}
+			if block.PayloadKind != pbbstream.Protocol_EOS {
+				return fmt.Errorf("block #%d: expected EOS blocks, not %s", block.Number, block.PayloadKind)
+			}
+
+			eosBlock := &pbdeos.Block{}
+			if err := proto.Unmarshal(block.PayloadBuffer, eosBlock); err != nil {
+				return fmt.Errorf("block #%d: proto unmarshal: %s", block.Number, err)
+			}
+
+			for _, trace := range eosBlock.TransactionTraces {
+				for _, record := range stats.addTrace(block.Number, trace) {
+					if err := output.write(record); err != nil {
+						return err
+					}
+				}
+			}
+			stats.blocks++
+		}
+	}
+
+	for _, group := range groups {
+		for _, record := range stats.records(group) {
+			if err := output.write(record); err != nil {
+				return err
+			}
+		}
+	}
+	if err := output.close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d blocks, %d transactions, %d account RAM deltas mismatching RAM ops\n", stats.blocks, stats.trxCount, stats.mismatches)
+	return nil
+}
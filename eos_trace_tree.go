@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	"github.com/dfuse-io/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// eosTraceTree is a transaction trace with its actions nested under the
+// action that created them.
+type eosTraceTree struct {
+	ID        string           `json:"id"`
+	BlockNum  uint64           `json:"block_num"`
+	Status    string           `json:"status"`
+	Exception string           `json:"exception,omitempty"`
+	Actions   []*eosActionNode `json:"actions"`
+}
+
+// eosActionNode is an action of a trace tree, holding the ops it caused
+// (matched by `ActionIndex`, the action's execution index) and the actions
+// it created: inline actions and notifications.
+type eosActionNode struct {
+	ExecutionIndex                  uint32            `json:"execution_index"`
+	ActionOrdinal                   uint32            `json:"action_ordinal,omitempty"`
+	ClosestUnnotifiedAncestorAction uint32            `json:"closest_unnotified_ancestor_action_ordinal,omitempty"`
+	Receiver                        string            `json:"receiver"`
+	Account                         string            `json:"account"`
+	Name                            string            `json:"name"`
+	Notification                    bool              `json:"notification,omitempty"`
+	Authorization                   []string          `json:"authorization,omitempty"`
+	Data                            json.RawMessage   `json:"data,omitempty"`
+	Console                         string            `json:"console,omitempty"`
+	Exception                       string            `json:"exception,omitempty"`
+	DBOps                           []json.RawMessage `json:"db_ops,omitempty"`
+	RAMOps                          []json.RawMessage `json:"ram_ops,omitempty"`
+	TableOps                        []json.RawMessage `json:"table_ops,omitempty"`
+	Children                        []*eosActionNode  `json:"children,omitempty"`
+
+	trace    *pbdeos.ActionTrace
+	dbOps    []*pbdeos.DBOp
+	ramOps   []*pbdeos.RAMOp
+	tableOps []*pbdeos.TableOp
+}
+
+var eosTraceTreesOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "id", path: "id"},
+		{header: "block_num", path: "block_num"},
+		{header: "status", path: "status"},
+		{header: "root_actions", path: "actions.#"},
+		{header: "exception", path: "exception"},
+	},
+}
+
+// nestEOSActions nests the actions of `trace` following its `CreationTree`
+// (each node pointing to its creator node and to its action by execution
+// index), or the actions' `CreatorActionOrdinal` for traces without one.
+func nestEOSActions(trace *pbdeos.TransactionTrace) (roots []*eosActionNode) {
+	newNode := func(index uint32) *eosActionNode {
+		node := &eosActionNode{ExecutionIndex: index}
+		if int(index) < len(trace.ActionTraces) {
+			node.trace = trace.ActionTraces[index]
+		}
+		return node
+	}
+
+	if len(trace.CreationTree) != 0 {
+		nodes := make([]*eosActionNode, len(trace.CreationTree))
+		for i, flat := range trace.CreationTree {
+			nodes[i] = newNode(flat.ExecutionActionIndex)
+		}
+		for i, flat := range trace.CreationTree {
+			parent := int(flat.CreatorActionIndex)
+			if parent < 0 || parent >= len(nodes) || parent == i {
+				roots = append(roots, nodes[i])
+				continue
+			}
+			nodes[parent].Children = append(nodes[parent].Children, nodes[i])
+		}
+		return roots
+	}
+
+	byOrdinal := map[uint32]*eosActionNode{}
+	nodes := make([]*eosActionNode, len(trace.ActionTraces))
+	for i, action := range trace.ActionTraces {
+		nodes[i] = newNode(uint32(i))
+		byOrdinal[action.ActionOrdinal] = nodes[i]
+	}
+	for i, action := range trace.ActionTraces {
+		parent := byOrdinal[action.CreatorActionOrdinal]
+		if action.CreatorActionOrdinal == 0 || parent == nil || parent == nodes[i] {
+			roots = append(roots, nodes[i])
+			continue
+		}
+		parent.Children = append(parent.Children, nodes[i])
+	}
+	return roots
+}
+
+var opsMarshaler = jsonpb.Marshaler{OrigName: true}
+
+func opJSON(op proto.Message) (json.RawMessage, error) {
+	out, err := opsMarshaler.MarshalToString(op)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal: %s", err)
+	}
+	return json.RawMessage(out), nil
+}
+
+func newEOSTraceTree(trace *pbdeos.TransactionTrace) (*eosTraceTree, error) {
+	tree := &eosTraceTree{ID: trace.Id, BlockNum: trace.BlockNum, Status: "?", Actions: nestEOSActions(trace)}
+	if trace.Receipt != nil {
+		tree.Status = trimEnumPrefix(trace.Receipt.Status, "TRANSACTIONSTATUS_")
+	}
+	if trace.Exception != nil {
+		tree.Exception = formatEOSException(trace.Exception)
+	}
+
+	byIndex := map[uint32]*eosActionNode{}
+	var fill func(nodes []*eosActionNode)
+	fill = func(nodes []*eosActionNode) {
+		for _, node := range nodes {
+			byIndex[node.ExecutionIndex] = node
+			node.fill()
+			fill(node.Children)
+		}
+	}
+	fill(tree.Actions)
+
+	for _, op := range trace.DbOps {
+		if node := byIndex[op.ActionIndex]; node != nil {
+			node.dbOps = append(node.dbOps, op)
+		}
+	}
+	for _, op := range trace.RamOps {
+		if node := byIndex[op.ActionIndex]; node != nil {
+			node.ramOps = append(node.ramOps, op)
+		}
+	}
+	for _, op := range trace.TableOps {
+		if node := byIndex[op.ActionIndex]; node != nil {
+			node.tableOps = append(node.tableOps, op)
+		}
+	}
+
+	for _, node := range byIndex {
+		if err := node.fillOps(); err != nil {
+			return nil, fmt.Errorf("trx %s: %s", trace.Id, err)
+		}
+	}
+
+	return tree, nil
+}
+
+// fill copies the action fields worth seeing in a tree
+func (n *eosActionNode) fill() {
+	trace := n.trace
+	if trace == nil {
+		return
+	}
+
+	n.ActionOrdinal = trace.ActionOrdinal
+	n.ClosestUnnotifiedAncestorAction = trace.ClosestUnnotifiedAncestorActionOrdinal
+	n.Receiver = trace.Receiver
+	n.Console = trace.Console
+	if trace.Exception != nil {
+		n.Exception = formatEOSException(trace.Exception)
+	}
+
+	action := trace.Action
+	if action == nil {
+		return
+	}
+
+	n.Account, n.Name = action.Account, action.Name
+	n.Notification = trace.Receiver != action.Account
+	for _, auth := range action.Authorization {
+		n.Authorization = append(n.Authorization, auth.Actor+"@"+auth.Permission)
+	}
+
+	switch {
+	case action.JsonData != "" && json.Valid([]byte(action.JsonData)):
+		n.Data = json.RawMessage(action.JsonData)
+	case len(action.RawData) != 0:
+		n.Data, _ = json.Marshal(hex.EncodeToString(action.RawData))
+	}
+}
+
+func (n *eosActionNode) fillOps() error {
+	for _, op := range n.dbOps {
+		out, err := opJSON(op)
+		if err != nil {
+			return err
+		}
+		n.DBOps = append(n.DBOps, out)
+	}
+	for _, op := range n.ramOps {
+		out, err := opJSON(op)
+		if err != nil {
+			return err
+		}
+		n.RAMOps = append(n.RAMOps, out)
+	}
+	for _, op := range n.tableOps {
+		out, err := opJSON(op)
+		if err != nil {
+			return err
+		}
+		n.TableOps = append(n.TableOps, out)
+	}
+	return nil
+}
+
+func formatEOSException(exception *pbdeos.Exception) string {
+	return fmt.Sprintf("%d %s: %s", exception.Code, exception.Name, exception.Message)
+}
+
+// textTree lays the tree out for `writeTextTree`, ops before the actions
+// they led to.
+func (t *eosTraceTree) textTree() *blockTreeNode {
+	root := newTreeNode("trx %s %s (block %d)", t.ID, t.Status, t.BlockNum)
+	if t.Exception != "" {
+		root.add(newTreeNode("exception: %s", t.Exception))
+	}
+	for _, action := range t.Actions {
+		root.add(action.textTree())
+	}
+	return root
+}
+
+func (n *eosActionNode) textTree() *blockTreeNode {
+	label := fmt.Sprintf("#%d %s::%s", n.ExecutionIndex, n.Account, n.Name)
+	if n.Notification {
+		label += " -> " + n.Receiver
+	}
+	if len(n.Data) != 0 {
+		label += " " + string(n.Data)
+	}
+	node := newTreeNode("%s", label)
+
+	if n.trace != nil {
+		for _, delta := range n.trace.AccountRamDeltas {
+			node.add(newTreeNode("ram delta %s %+d bytes", delta.Account, delta.Delta))
+		}
+	}
+	node.addText("console", n.Console)
+	if n.Exception != "" {
+		node.add(newTreeNode("exception: %s", n.Exception))
+	}
+
+	for _, op := range n.dbOps {
+		dbNode := eosDBOpTree(op)
+		dbNode.label = "db " + dbNode.label
+		node.add(dbNode)
+	}
+	for _, op := range n.ramOps {
+		node.add(newTreeNode("ram %s", eosRAMOpTree(op).label))
+	}
+	for _, op := range n.tableOps {
+		node.add(newTreeNode("table %s", eosTableOpTree(op).label))
+	}
+
+	for _, child := range n.Children {
+		node.add(child.textTree())
+	}
+	return node
+}
+
+// writeEOSTraceTrees writes the trees of `traces` in `mode`, records going
+// through `output` in `json` mode.
+func writeEOSTraceTrees(mode string, output *recordWriter, traces []*pbdeos.TransactionTrace) error {
+	for _, trace := range traces {
+		tree, err := newEOSTraceTree(trace)
+		if err != nil {
+			return err
+		}
+
+		if mode == "text" {
+			writeTextTree(os.Stdout, tree.textTree())
+			continue
+		}
+		if err := output.write(tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
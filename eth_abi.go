@@ -0,0 +1,831 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/sha3"
+)
+
+// Common token signatures, decoded with no setup. ERC-20 and ERC-721
+// Transfer and Approval share their topic0, the number of indexed
+// arguments telling them apart.
+var ethBuiltinABISignatures = []string{
+	"event Transfer(address indexed from, address indexed to, uint256 value)",
+	"event Approval(address indexed owner, address indexed spender, uint256 value)",
+	"event Transfer(address indexed from, address indexed to, uint256 indexed tokenId)",
+	"event Approval(address indexed owner, address indexed approved, uint256 indexed tokenId)",
+	"event ApprovalForAll(address indexed owner, address indexed operator, bool approved)",
+	"function transfer(address to, uint256 value)",
+	"function transferFrom(address from, address to, uint256 value)",
+	"function approve(address spender, uint256 value)",
+	"function balanceOf(address owner)",
+	"function allowance(address owner, address spender)",
+	"function totalSupply()",
+	"function name()",
+	"function symbol()",
+	"function decimals()",
+	"function ownerOf(uint256 tokenId)",
+	"function getApproved(uint256 tokenId)",
+	"function setApprovalForAll(address operator, bool approved)",
+	"function isApprovedForAll(address owner, address operator)",
+	"function safeTransferFrom(address from, address to, uint256 tokenId)",
+	"function safeTransferFrom(address from, address to, uint256 tokenId, bytes data)",
+}
+
+// addETHABIFlags adds the flags of the ABIs decoding ETH logs and call
+// inputs, read by `loadETHABIs`.
+func addETHABIFlags(cmd *cobra.Command) {
+	cmd.Flags().String("abi-dir", "", "directory of contract ABI JSON files named after their address, to decode ETH logs and call inputs")
+	cmd.Flags().StringArray("abi", nil, "event or function signatures to decode ETH logs and call inputs with, like 'event Deposit(address indexed dst, uint256 wad)' (repeatable, @file for one per line)")
+}
+
+// ethABIArgument is a function or event argument, as in ABI JSON
+type ethABIArgument struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Indexed    bool             `json:"indexed"`
+	Components []ethABIArgument `json:"components"`
+
+	parsed *ethABIType
+}
+
+// ethABIEntry is an ABI JSON entry, functions and events being the ones used
+type ethABIEntry struct {
+	Type      string           `json:"type"`
+	Name      string           `json:"name"`
+	Inputs    []ethABIArgument `json:"inputs"`
+	Anonymous bool             `json:"anonymous"`
+
+	signature string
+}
+
+// ethABISet indexes entries by topic0 (events) and selector (functions),
+// both hex encoded. Signatures can collide, so each has a list.
+type ethABISet struct {
+	events    map[string][]*ethABIEntry
+	functions map[string][]*ethABIEntry
+}
+
+func newETHABISet() *ethABISet {
+	return &ethABISet{events: map[string][]*ethABIEntry{}, functions: map[string][]*ethABIEntry{}}
+}
+
+// ethABIRegistry holds the contract ABIs by lowercase hex address (no 0x),
+// and the signatures used for every contract.
+type ethABIRegistry struct {
+	contracts map[string]*ethABISet
+	common    *ethABISet
+}
+
+var ethABIs = newETHABIRegistry()
+
+func newETHABIRegistry() *ethABIRegistry {
+	registry := &ethABIRegistry{contracts: map[string]*ethABISet{}, common: newETHABISet()}
+	for _, signature := range ethBuiltinABISignatures {
+		entry, err := parseETHABISignature(signature)
+		if err != nil {
+			panic(fmt.Errorf("built-in signature %q: %s", signature, err))
+		}
+		registry.common.add(entry)
+	}
+	return registry
+}
+
+// loadETHABIs adds the `--abi` signatures and `--abi-dir` ABIs of `cmdKey`
+// to the built-in ones.
+func loadETHABIs(cmdKey string) error {
+	values, err := viperStringArray(cmdKey + "-abi")
+	if err != nil {
+		return fmt.Errorf("--abi: %s", err)
+	}
+
+	for _, value := range values {
+		signatures := []string{value}
+		if strings.HasPrefix(value, "@") {
+			var err error
+			if signatures, err = readSignaturesFile(value[1:]); err != nil {
+				return err
+			}
+		}
+
+		for _, signature := range signatures {
+			entry, err := parseETHABISignature(signature)
+			if err != nil {
+				return fmt.Errorf("invalid --abi %q: %s", signature, err)
+			}
+			// Given signatures win over built-in ones
+			ethABIs.common.prepend(entry)
+		}
+	}
+
+	if dir := viper.GetString(cmdKey + "-abi-dir"); dir != "" {
+		return ethABIs.loadDir(dir)
+	}
+	return nil
+}
+
+// viperStringArray reads a `StringArray` flag, which viper only has in its
+// `[a,"b, c"]` CSV form. Other values, like environment ones, are one item.
+func viperStringArray(key string) ([]string, error) {
+	value := viper.GetString(key)
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		if value == "" {
+			return nil, nil
+		}
+		return []string{value}, nil
+	}
+
+	value = value[1 : len(value)-1]
+	if value == "" {
+		return nil, nil
+	}
+	return csv.NewReader(strings.NewReader(value)).Read()
+}
+
+func readSignaturesFile(path string) (signatures []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			signatures = append(signatures, line)
+		}
+	}
+	return signatures, scanner.Err()
+}
+
+func (r *ethABIRegistry) loadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading abi dir: %s", err)
+	}
+
+	for _, file := range files {
+		name := strings.ToLower(strings.TrimSuffix(file.Name(), ".json"))
+		name = strings.TrimPrefix(name, "0x")
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") || len(name) != 40 {
+			continue
+		}
+		if _, err := hex.DecodeString(name); err != nil {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		entries, err := parseETHABIJSON(content)
+		if err != nil {
+			return fmt.Errorf("abi %s: %s", file.Name(), err)
+		}
+
+		set := newETHABISet()
+		for _, entry := range entries {
+			set.add(entry)
+		}
+		r.contracts[name] = set
+	}
+	return nil
+}
+
+// parseETHABIJSON reads an ABI array, or an artifact holding it under "abi"
+func parseETHABIJSON(content []byte) ([]*ethABIEntry, error) {
+	var entries []*ethABIEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		var artifact struct {
+			ABI []*ethABIEntry `json:"abi"`
+		}
+		if artifactErr := json.Unmarshal(content, &artifact); artifactErr != nil || artifact.ABI == nil {
+			return nil, fmt.Errorf("expected an ABI array or an object with an abi field: %s", err)
+		}
+		entries = artifact.ABI
+	}
+
+	var out []*ethABIEntry
+	for _, entry := range entries {
+		if entry.Type != "event" && entry.Type != "function" && entry.Type != "" {
+			continue
+		}
+		if entry.Type == "" {
+			entry.Type = "function"
+		}
+		if err := entry.prepare(); err != nil {
+			return nil, fmt.Errorf("%s %s: %s", entry.Type, entry.Name, err)
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// parseETHABISignature reads a Solidity-like signature, `event` or
+// `function` (the default) followed by the name and arguments, argument
+// names and `indexed` being optional. What follows the arguments
+// (modifiers, returns) is ignored.
+func parseETHABISignature(signature string) (*ethABIEntry, error) {
+	entry := &ethABIEntry{Type: "function"}
+	rest := strings.TrimSpace(signature)
+	for _, kind := range []string{"event", "function"} {
+		if strings.HasPrefix(rest, kind+" ") {
+			entry.Type, rest = kind, strings.TrimSpace(rest[len(kind)+1:])
+		}
+	}
+
+	open := strings.Index(rest, "(")
+	if open <= 0 {
+		return nil, fmt.Errorf("expected name(arguments)")
+	}
+	entry.Name = strings.TrimSpace(rest[:open])
+
+	end, err := matchingParen(rest, open)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Inputs, err = parseETHABIArguments(rest[open+1 : end]); err != nil {
+		return nil, err
+	}
+	if strings.Contains(rest[end+1:], "anonymous") {
+		entry.Anonymous = true
+	}
+
+	if err := entry.prepare(); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func matchingParen(text string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unbalanced parentheses")
+}
+
+// parseETHABIArguments reads comma separated `type [indexed] [name]`
+// arguments, tuple types being written `(type, ...)`.
+func parseETHABIArguments(list string) (args []ethABIArgument, err error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	depth, start := 0, 0
+	var parts []string
+	for i, char := range list {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, list[start:])
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		arg := ethABIArgument{}
+
+		typ := part
+		if strings.HasPrefix(part, "(") {
+			end, err := matchingParen(part, 0)
+			if err != nil {
+				return nil, err
+			}
+			if arg.Components, err = parseETHABIArguments(part[1:end]); err != nil {
+				return nil, err
+			}
+			suffixEnd := end + 1
+			for suffixEnd < len(part) && part[suffixEnd] != ' ' {
+				suffixEnd++
+			}
+			typ = "tuple" + part[end+1:suffixEnd]
+			part = part[suffixEnd:]
+		} else {
+			fields := strings.Fields(part)
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("empty argument")
+			}
+			typ, part = fields[0], strings.Join(fields[1:], " ")
+		}
+
+		arg.Type = typ
+		for _, word := range strings.Fields(part) {
+			switch word {
+			case "indexed":
+				arg.Indexed = true
+			case "memory", "calldata", "storage", "payable":
+			default:
+				arg.Name = word
+			}
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// prepare parses the argument types and computes the canonical signature
+func (e *ethABIEntry) prepare() error {
+	var types []string
+	for i := range e.Inputs {
+		parsed, err := parseETHABIType(e.Inputs[i].Type, e.Inputs[i].Components)
+		if err != nil {
+			return err
+		}
+		e.Inputs[i].parsed = parsed
+		types = append(types, parsed.canonical())
+	}
+	e.signature = e.Name + "(" + strings.Join(types, ",") + ")"
+	return nil
+}
+
+func (e *ethABIEntry) indexedCount() (count int) {
+	for _, input := range e.Inputs {
+		if input.Indexed {
+			count++
+		}
+	}
+	return count
+}
+
+func keccak256(data []byte) []byte {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(data)
+	return hash.Sum(nil)
+}
+
+func (s *ethABISet) add(entry *ethABIEntry) {
+	s.insert(entry, false)
+}
+
+func (s *ethABISet) prepend(entry *ethABIEntry) {
+	s.insert(entry, true)
+}
+
+// insert indexes `entry`, except anonymous events which have no topic0
+func (s *ethABISet) insert(entry *ethABIEntry, first bool) {
+	if entry.Anonymous {
+		return
+	}
+
+	hash := hex.EncodeToString(keccak256([]byte(entry.signature)))
+	index := s.functions
+	if entry.Type == "event" {
+		index = s.events
+	} else {
+		hash = hash[:8]
+	}
+
+	if first {
+		index[hash] = append([]*ethABIEntry{entry}, index[hash]...)
+		return
+	}
+	index[hash] = append(index[hash], entry)
+}
+
+// candidates returns the entries of `address` then the common ones
+func (r *ethABIRegistry) candidates(address []byte, events bool, key string) (out []*ethABIEntry) {
+	for _, set := range []*ethABISet{r.contracts[hex.EncodeToString(address)], r.common} {
+		if set == nil {
+			continue
+		}
+		if events {
+			out = append(out, set.events[key]...)
+		} else {
+			out = append(out, set.functions[key]...)
+		}
+	}
+	return out
+}
+
+// ethDecoded is a decoded log or call input. `Error` is set when entries
+// matched but none decoded, the last error being kept.
+type ethDecoded struct {
+	Signature string       `json:"signature"`
+	Args      ethABIValues `json:"args,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// String formats a decoded value for trees, `signature {name: value, ...}`
+func (d *ethDecoded) String() string {
+	if d.Error != "" {
+		return fmt.Sprintf("%s (not decoded: %s)", d.Signature, d.Error)
+	}
+	return fmt.Sprintf("%s {%s}", d.Signature, d.Args)
+}
+
+// decodeLog decodes a log against the events of its contract and the
+// common ones, nil when no event matches its topic0.
+func (r *ethABIRegistry) decodeLog(address []byte, topics [][]byte, data []byte) *ethDecoded {
+	if len(topics) == 0 {
+		return nil
+	}
+
+	var decoded *ethDecoded
+	for _, entry := range r.candidates(address, true, hex.EncodeToString(topics[0])) {
+		if entry.indexedCount() != len(topics)-1 {
+			continue
+		}
+
+		args, err := entry.decodeLog(topics[1:], data)
+		decoded = &ethDecoded{Signature: entry.signature, Args: args}
+		if err == nil {
+			return decoded
+		}
+		decoded.Args, decoded.Error = nil, err.Error()
+	}
+	return decoded
+}
+
+// decodeInput decodes a call input against the functions of the called
+// contract and the common ones, nil when no function matches its selector.
+func (r *ethABIRegistry) decodeInput(address []byte, input []byte) *ethDecoded {
+	if len(input) < 4 {
+		return nil
+	}
+
+	var decoded *ethDecoded
+	for _, entry := range r.candidates(address, false, hex.EncodeToString(input[:4])) {
+		args, err := decodeETHABITuple(entry.Inputs, input[4:])
+		decoded = &ethDecoded{Signature: entry.signature, Args: args}
+		if err == nil {
+			return decoded
+		}
+		decoded.Args, decoded.Error = nil, err.Error()
+	}
+	return decoded
+}
+
+// decodeLog decodes indexed arguments out of `topics`, dynamic ones being
+// left as the topic (their hash), and the others out of `data`.
+func (e *ethABIEntry) decodeLog(topics [][]byte, data []byte) (ethABIValues, error) {
+	var unindexed []ethABIArgument
+	for _, input := range e.Inputs {
+		if !input.Indexed {
+			unindexed = append(unindexed, input)
+		}
+	}
+	dataValues, err := decodeETHABITuple(unindexed, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var values ethABIValues
+	for i, input := range e.Inputs {
+		name := argumentName(input, i)
+		if !input.Indexed {
+			value := dataValues[0]
+			value.Name, dataValues = name, dataValues[1:]
+			values = append(values, value)
+			continue
+		}
+
+		topic := topics[0]
+		topics = topics[1:]
+		if input.parsed.isDynamic() || input.parsed.kind == "tuple" || input.parsed.kind == "fixedarray" {
+			values = append(values, ethABIValue{Name: name, Value: "0x" + hex.EncodeToString(topic)})
+			continue
+		}
+		value, err := input.parsed.decode(topic, 0)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %s", name, err)
+		}
+		values = append(values, ethABIValue{Name: name, Value: value})
+	}
+	return values, nil
+}
+
+func argumentName(arg ethABIArgument, index int) string {
+	if arg.Name == "" {
+		return "arg" + strconv.Itoa(index)
+	}
+	return arg.Name
+}
+
+// ethABIValue is a named decoded value, ethABIValues keeping their order
+// in JSON.
+type ethABIValue struct {
+	Name  string
+	Value interface{}
+}
+
+type ethABIValues []ethABIValue
+
+func (v ethABIValues) MarshalJSON() ([]byte, error) {
+	out := []byte{'{'}
+	for i, value := range v {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		name, _ := json.Marshal(value.Name)
+		data, err := json.Marshal(value.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(append(append(out, name...), ':'), data...)
+	}
+	return append(out, '}'), nil
+}
+
+// String formats values as `name: value, ...` for text trees
+func (v ethABIValues) String() string {
+	var parts []string
+	for _, value := range v {
+		data, _ := json.Marshal(value.Value)
+		text := string(data)
+		if s, ok := value.Value.(string); ok {
+			text = s
+		}
+		parts = append(parts, value.Name+": "+text)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ethABIType is a parsed Solidity type. `size` is the bit size of
+// integers, the byte size of fixed bytes and the length of fixed arrays.
+type ethABIType struct {
+	kind       string // uint, int, address, bool, fixedbytes, bytes, string, array, fixedarray, tuple
+	size       int
+	elem       *ethABIType
+	components []ethABIArgument
+}
+
+func parseETHABIType(typ string, components []ethABIArgument) (*ethABIType, error) {
+	if strings.HasSuffix(typ, "]") {
+		open := strings.LastIndex(typ, "[")
+		if open < 0 {
+			return nil, fmt.Errorf("invalid type %q", typ)
+		}
+		elem, err := parseETHABIType(typ[:open], components)
+		if err != nil {
+			return nil, err
+		}
+
+		length := typ[open+1 : len(typ)-1]
+		if length == "" {
+			return &ethABIType{kind: "array", elem: elem}, nil
+		}
+		size, err := strconv.Atoi(length)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid array length in %q", typ)
+		}
+		return &ethABIType{kind: "fixedarray", size: size, elem: elem}, nil
+	}
+
+	switch {
+	case typ == "tuple":
+		out := &ethABIType{kind: "tuple", components: append([]ethABIArgument(nil), components...)}
+		for i := range out.components {
+			parsed, err := parseETHABIType(out.components[i].Type, out.components[i].Components)
+			if err != nil {
+				return nil, err
+			}
+			out.components[i].parsed = parsed
+		}
+		return out, nil
+	case typ == "address", typ == "bool", typ == "string", typ == "bytes":
+		return &ethABIType{kind: typ}, nil
+	case typ == "uint", typ == "int":
+		return &ethABIType{kind: typ, size: 256}, nil
+	case typ == "function":
+		return &ethABIType{kind: "fixedbytes", size: 24}, nil
+	}
+
+	for _, kind := range []string{"uint", "int", "bytes"} {
+		if !strings.HasPrefix(typ, kind) {
+			continue
+		}
+		size, err := strconv.Atoi(typ[len(kind):])
+		if err != nil {
+			break
+		}
+		if kind == "bytes" {
+			if size < 1 || size > 32 {
+				break
+			}
+			return &ethABIType{kind: "fixedbytes", size: size}, nil
+		}
+		if size < 8 || size > 256 || size%8 != 0 {
+			break
+		}
+		return &ethABIType{kind: kind, size: size}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", typ)
+}
+
+// canonical is the type as written in signatures hashed for selectors
+func (t *ethABIType) canonical() string {
+	switch t.kind {
+	case "uint", "int":
+		return t.kind + strconv.Itoa(t.size)
+	case "fixedbytes":
+		return "bytes" + strconv.Itoa(t.size)
+	case "array":
+		return t.elem.canonical() + "[]"
+	case "fixedarray":
+		return t.elem.canonical() + "[" + strconv.Itoa(t.size) + "]"
+	case "tuple":
+		var types []string
+		for _, component := range t.components {
+			types = append(types, component.parsed.canonical())
+		}
+		return "(" + strings.Join(types, ",") + ")"
+	}
+	return t.kind
+}
+
+func (t *ethABIType) isDynamic() bool {
+	switch t.kind {
+	case "bytes", "string", "array":
+		return true
+	case "fixedarray":
+		return t.elem.isDynamic()
+	case "tuple":
+		for _, component := range t.components {
+			if component.parsed.isDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize is the size of the type in the head of a tuple encoding
+func (t *ethABIType) headSize() int {
+	if t.isDynamic() {
+		return 32
+	}
+	switch t.kind {
+	case "fixedarray":
+		return t.size * t.elem.headSize()
+	case "tuple":
+		size := 0
+		for _, component := range t.components {
+			size += component.parsed.headSize()
+		}
+		return size
+	}
+	return 32
+}
+
+func readABIWord(data []byte, offset int) ([]byte, error) {
+	if offset < 0 || offset+32 > len(data) {
+		return nil, fmt.Errorf("data too short, %d bytes for a word at %d", len(data), offset)
+	}
+	return data[offset : offset+32], nil
+}
+
+// readABIInt reads a word used as an offset or a length, bounded by `data`
+func readABIInt(data []byte, offset int) (int, error) {
+	word, err := readABIWord(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	value := new(big.Int).SetBytes(word)
+	if !value.IsInt64() || value.Int64() > int64(len(data)) {
+		return 0, fmt.Errorf("offset or length %s out of the %d bytes of data", value, len(data))
+	}
+	return int(value.Int64()), nil
+}
+
+// decodeETHABITuple decodes `args` encoded one after the other, dynamic
+// ones through an offset, like function inputs.
+func decodeETHABITuple(args []ethABIArgument, data []byte) (ethABIValues, error) {
+	values := ethABIValues{}
+	head := 0
+	for i, arg := range args {
+		name := argumentName(arg, i)
+		var value interface{}
+		var err error
+		if arg.parsed.isDynamic() {
+			var offset int
+			if offset, err = readABIInt(data, head); err == nil {
+				value, err = arg.parsed.decode(data[offset:], 0)
+			}
+		} else {
+			value, err = arg.parsed.decode(data, head)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		values = append(values, ethABIValue{Name: name, Value: value})
+		head += arg.parsed.headSize()
+	}
+	return values, nil
+}
+
+// decode reads a value at `offset` of `data`. Integers are decimal strings,
+// bytes and addresses 0x prefixed hex.
+func (t *ethABIType) decode(data []byte, offset int) (interface{}, error) {
+	if offset > len(data) {
+		return nil, fmt.Errorf("data too short, %d bytes for a value at %d", len(data), offset)
+	}
+
+	switch t.kind {
+	case "uint", "int":
+		word, err := readABIWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		value := new(big.Int).SetBytes(word)
+		if t.kind == "int" && word[0]&0x80 != 0 {
+			value.Sub(value, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		return value.String(), nil
+
+	case "address":
+		word, err := readABIWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		return "0x" + hex.EncodeToString(word[12:]), nil
+
+	case "bool":
+		word, err := readABIWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		return word[31] != 0, nil
+
+	case "fixedbytes":
+		word, err := readABIWord(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		return "0x" + hex.EncodeToString(word[:t.size]), nil
+
+	case "bytes", "string":
+		length, err := readABIInt(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		start := offset + 32
+		if start+length > len(data) {
+			return nil, fmt.Errorf("%s of %d bytes past the end of data", t.kind, length)
+		}
+		if t.kind == "string" {
+			return string(data[start : start+length]), nil
+		}
+		return "0x" + hex.EncodeToString(data[start:start+length]), nil
+
+	case "array", "fixedarray":
+		length, content := t.size, data[offset:]
+		if t.kind == "array" {
+			var err error
+			if length, err = readABIInt(data, offset); err != nil {
+				return nil, err
+			}
+			content = data[offset+32:]
+		}
+		if length*t.elem.headSize() > len(content) {
+			return nil, fmt.Errorf("array of %d elements past the end of data", length)
+		}
+
+		elems := make([]ethABIArgument, length)
+		for i := range elems {
+			elems[i] = ethABIArgument{Name: strconv.Itoa(i), parsed: t.elem}
+		}
+		values, err := decodeETHABITuple(elems, content)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(values))
+		for i, value := range values {
+			out[i] = value.Value
+		}
+		return out, nil
+
+	case "tuple":
+		return decodeETHABITuple(t.components, data[offset:])
+	}
+	return nil, fmt.Errorf("unsupported type %s", t.kind)
+}
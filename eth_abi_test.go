@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// abiData concatenates hex words, spaces and newlines being ignored
+func abiData(t *testing.T, words ...string) []byte {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(strings.Join(words, "")), ""))
+	if err != nil {
+		t.Fatalf("invalid test data: %s", err)
+	}
+	return data
+}
+
+func abiWord(hexValue string) string {
+	return strings.Repeat("0", 64-len(hexValue)) + hexValue
+}
+
+func selectorOf(signature string) string {
+	return hex.EncodeToString(keccak256([]byte(signature))[:4])
+}
+
+func abiAddress(last byte) []byte {
+	address := make([]byte, 20)
+	address[19] = last
+	return address
+}
+
+func testETHABIRegistry(t *testing.T, signatures ...string) *ethABIRegistry {
+	registry := newETHABIRegistry()
+	for _, signature := range signatures {
+		entry, err := parseETHABISignature(signature)
+		if err != nil {
+			t.Fatalf("signature %q: %s", signature, err)
+		}
+		registry.common.prepend(entry)
+	}
+	return registry
+}
+
+func decodedJSON(t *testing.T, decoded *ethDecoded) string {
+	if decoded == nil {
+		return "<nil>"
+	}
+	if decoded.Error != "" {
+		return decoded.Signature + " error"
+	}
+
+	data, err := json.Marshal(decoded.Args)
+	if err != nil {
+		t.Fatalf("marshaling args: %s", err)
+	}
+	return decoded.Signature + " " + string(data)
+}
+
+func TestParseETHABISignature(t *testing.T) {
+	tests := []struct {
+		signature         string
+		expectedSignature string
+		expectedHash      string
+		expectedErr       bool
+	}{
+		// Selectors and topics from the Solidity ABI specification and ERC-20/721
+		{"function transfer(address to, uint256 value)", "transfer(address,uint256)", "a9059cbb", false},
+		{"baz(uint32 x, bool y)", "baz(uint32,bool)", "cdcd77c0", false},
+		{"function bar(bytes3[2] memory)", "bar(bytes3[2])", "fce353f6", false},
+		{"function sam(bytes memory, bool, uint[] memory)", "sam(bytes,bool,uint256[])", "a5643bf2", false},
+		{"function f(uint, uint32[], bytes10, bytes)", "f(uint256,uint32[],bytes10,bytes)", "8be65246", false},
+		{"event Transfer(address indexed from, address indexed to, uint256 value)", "Transfer(address,address,uint256)", "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", false},
+		{"event Approval(address indexed owner, address indexed spender, uint256 value)", "Approval(address,address,uint256)", "8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925", false},
+		{"function t((uint256 a, string s)[] p, int8 n) external returns (bool)", "t((uint256,string)[],int8)", "", false},
+
+		{"function bad(", "", "", true},
+		{"(uint256)", "", "", true},
+		{"function f(uint7)", "", "", true},
+		{"function f(bytes33)", "", "", true},
+		{"function f(uint256[0])", "", "", true},
+		{"function f(, uint256)", "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.signature, func(t *testing.T) {
+			entry, err := parseETHABISignature(test.signature)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", entry.signature)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if entry.signature != test.expectedSignature {
+				t.Errorf("expected %s, got %s", test.expectedSignature, entry.signature)
+			}
+			if hash := hex.EncodeToString(keccak256([]byte(entry.signature))); !strings.HasPrefix(hash, test.expectedHash) {
+				t.Errorf("expected hash %s, got %s", test.expectedHash, hash)
+			}
+		})
+	}
+}
+
+func TestETHABIDecodeInput(t *testing.T) {
+	registry := testETHABIRegistry(t,
+		"function sam(bytes, bool, uint256[])",
+		"function f(uint256, uint32[], bytes10, bytes)",
+		"function greet(string text, address[2] pair)",
+		"function signed(int8 small, int256 big, int256 positive)",
+		"function t((uint256 a, string s) p, int8 n)",
+		"function many((uint64 x, bytes2 y)[] points)",
+	)
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{
+			"erc20 transfer",
+			abiData(t, "a9059cbb", abiWord("03"), abiWord("03e8")),
+			`transfer(address,uint256) {"to":"0x0000000000000000000000000000000000000003","value":"1000"}`,
+		},
+		{
+			"erc721 safeTransferFrom with data",
+			abiData(t, "b88d4fde", abiWord("01"), abiWord("02"), abiWord("2a"), abiWord("80"), abiWord("02"), "beef"+strings.Repeat("0", 60)),
+			`safeTransferFrom(address,address,uint256,bytes) {"from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"42","data":"0xbeef"}`,
+		},
+		{
+			"bytes, bool and dynamic array (Solidity spec sam)",
+			abiData(t, "a5643bf2",
+				abiWord("60"), abiWord("01"), abiWord("a0"),
+				abiWord("04"), "64617665"+strings.Repeat("0", 56),
+				abiWord("03"), abiWord("01"), abiWord("02"), abiWord("03")),
+			`sam(bytes,bool,uint256[]) {"arg0":"0x64617665","arg1":true,"arg2":["1","2","3"]}`,
+		},
+		{
+			"static and dynamic mix (Solidity spec f)",
+			abiData(t, "8be65246",
+				abiWord("0123"), abiWord("80"), "31323334353637383930"+strings.Repeat("0", 44), abiWord("e0"),
+				abiWord("02"), abiWord("0456"), abiWord("0789"),
+				abiWord("0d"), "48656c6c6f2c20776f726c6421"+strings.Repeat("0", 38)),
+			`f(uint256,uint32[],bytes10,bytes) {"arg0":"291","arg1":["1110","1929"],"arg2":"0x31323334353637383930","arg3":"0x48656c6c6f2c20776f726c6421"}`,
+		},
+		{
+			"string and fixed array",
+			abiData(t, selectorOf("greet(string,address[2])"),
+				abiWord("60"), abiWord("0a"), abiWord("0b"),
+				abiWord("0d"), "48656c6c6f2c20776f726c6421"+strings.Repeat("0", 38)),
+			`greet(string,address[2]) {"text":"Hello, world!","pair":["0x000000000000000000000000000000000000000a","0x000000000000000000000000000000000000000b"]}`,
+		},
+		{
+			"negative integers",
+			abiData(t, selectorOf("signed(int8,int256,int256)"),
+				strings.Repeat("f", 64), strings.Repeat("f", 63)+"e", abiWord("7f")),
+			`signed(int8,int256,int256) {"small":"-1","big":"-2","positive":"127"}`,
+		},
+		{
+			"dynamic tuple",
+			abiData(t, selectorOf("t((uint256,string),int8)"),
+				abiWord("40"), strings.Repeat("f", 62)+"fb",
+				abiWord("07"), abiWord("40"), abiWord("02"), "6869"+strings.Repeat("0", 60)),
+			`t((uint256,string),int8) {"p":{"a":"7","s":"hi"},"n":"-5"}`,
+		},
+		{
+			"array of static tuples",
+			abiData(t, selectorOf("many((uint64,bytes2)[])"),
+				abiWord("20"), abiWord("02"),
+				abiWord("01"), "aabb"+strings.Repeat("0", 60),
+				abiWord("02"), "ccdd"+strings.Repeat("0", 60)),
+			`many((uint64,bytes2)[]) {"points":[{"x":"1","y":"0xaabb"},{"x":"2","y":"0xccdd"}]}`,
+		},
+		{
+			"no arguments",
+			abiData(t, "18160ddd"),
+			`totalSupply() {}`,
+		},
+
+		{"unknown selector", abiData(t, "deadbeef", abiWord("01")), "<nil>"},
+		{"no selector", abiData(t, "a905"), "<nil>"},
+
+		{"truncated word", abiData(t, "a9059cbb", abiWord("03"), abiWord("03e8")[:56]), "transfer(address,uint256) error"},
+		{"missing word", abiData(t, "a9059cbb", abiWord("03")), "transfer(address,uint256) error"},
+		{
+			"offset past the end",
+			abiData(t, "a5643bf2", abiWord("1000"), abiWord("01"), abiWord("a0")),
+			"sam(bytes,bool,uint256[]) error",
+		},
+		{
+			"huge offset",
+			abiData(t, "a5643bf2", strings.Repeat("f", 64), abiWord("01"), abiWord("a0")),
+			"sam(bytes,bool,uint256[]) error",
+		},
+		{
+			"bytes length past the end",
+			abiData(t, "a5643bf2", abiWord("60"), abiWord("01"), abiWord("a0"), abiWord("40"), abiWord("00")),
+			"sam(bytes,bool,uint256[]) error",
+		},
+		{
+			"array length past the end",
+			abiData(t, "a5643bf2",
+				abiWord("60"), abiWord("01"), abiWord("a0"),
+				abiWord("04"), "64617665"+strings.Repeat("0", 56),
+				abiWord("0100"), abiWord("01")),
+			"sam(bytes,bool,uint256[]) error",
+		},
+		{
+			"huge array length",
+			abiData(t, "a5643bf2",
+				abiWord("60"), abiWord("01"), abiWord("a0"),
+				abiWord("04"), "64617665"+strings.Repeat("0", 56),
+				strings.Repeat("f", 64)),
+			"sam(bytes,bool,uint256[]) error",
+		},
+		{
+			"tuple offset past the end",
+			abiData(t, selectorOf("t((uint256,string),int8)"), abiWord("40"), abiWord("01"), abiWord("07"), abiWord("ff")),
+			"t((uint256,string),int8) error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := decodedJSON(t, registry.decodeInput(abiAddress(2), test.input))
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+
+			// Any truncation must fail cleanly
+			for end := 0; end < len(test.input); end++ {
+				registry.decodeInput(abiAddress(2), test.input[:end])
+			}
+		})
+	}
+}
+
+func TestETHABIDecodeLog(t *testing.T) {
+	registry := testETHABIRegistry(t,
+		"event Named(string indexed name, uint256 indexed id, (uint256 a, bool b) indexed pair, bytes data)",
+	)
+
+	transfer := abiData(t, "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+	approval := abiData(t, "8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")
+	named := keccak256([]byte("Named(string,uint256,(uint256,bool),bytes)"))
+	nameHash := keccak256([]byte("alice"))
+
+	tests := []struct {
+		name     string
+		topics   [][]byte
+		data     []byte
+		expected string
+	}{
+		{
+			"erc20 transfer",
+			[][]byte{transfer, abiData(t, abiWord("01")), abiData(t, abiWord("02"))},
+			abiData(t, abiWord("03e8")),
+			`Transfer(address,address,uint256) {"from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","value":"1000"}`,
+		},
+		{
+			"erc721 transfer",
+			[][]byte{transfer, abiData(t, abiWord("01")), abiData(t, abiWord("02")), abiData(t, abiWord("2a"))},
+			nil,
+			`Transfer(address,address,uint256) {"from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002","tokenId":"42"}`,
+		},
+		{
+			"erc20 approval",
+			[][]byte{approval, abiData(t, abiWord("01")), abiData(t, abiWord("02"))},
+			abiData(t, strings.Repeat("f", 64)),
+			`Approval(address,address,uint256) {"owner":"0x0000000000000000000000000000000000000001","spender":"0x0000000000000000000000000000000000000002","value":"115792089237316195423570985008687907853269984665640564039457584007913129639935"}`,
+		},
+		{
+			"erc721 approval",
+			[][]byte{approval, abiData(t, abiWord("01")), abiData(t, abiWord("02")), abiData(t, abiWord("07"))},
+			nil,
+			`Approval(address,address,uint256) {"owner":"0x0000000000000000000000000000000000000001","approved":"0x0000000000000000000000000000000000000002","tokenId":"7"}`,
+		},
+		{
+			"indexed dynamic and tuple arguments stay hashes",
+			[][]byte{named, nameHash, abiData(t, abiWord("05")), nameHash},
+			abiData(t, abiWord("20"), abiWord("01"), "ff"+strings.Repeat("0", 62)),
+			`Named(string,uint256,(uint256,bool),bytes) {"name":"0x` + hex.EncodeToString(nameHash) + `","id":"5","pair":"0x` + hex.EncodeToString(nameHash) + `","data":"0xff"}`,
+		},
+
+		{"indexed count matching no event", [][]byte{transfer, abiData(t, abiWord("01"))}, abiData(t, abiWord("01")), "<nil>"},
+		{"unknown topic", [][]byte{abiData(t, abiWord("01"))}, nil, "<nil>"},
+		{"no topics", nil, nil, "<nil>"},
+		{"truncated data", [][]byte{transfer, abiData(t, abiWord("01")), abiData(t, abiWord("02"))}, abiData(t, "03e8"), "Transfer(address,address,uint256) error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := decodedJSON(t, registry.decodeLog(abiAddress(2), test.topics, test.data))
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestETHABIRegistryContractABI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doh-abi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifact := `{"abi": [
+		{"type": "function", "name": "transfer", "inputs": [{"name": "recipient", "type": "address"}, {"name": "amount", "type": "uint256"}]},
+		{"type": "event", "name": "Anon", "anonymous": true, "inputs": []},
+		{"type": "constructor", "inputs": []}
+	]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "0x"+hex.EncodeToString(abiAddress(2))+".json"), []byte(artifact), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := newETHABIRegistry()
+	if err := registry.loadDir(dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	input := abiData(t, "a9059cbb", abiWord("03"), abiWord("03e8"))
+	expected := `transfer(address,uint256) {"recipient":"0x0000000000000000000000000000000000000003","amount":"1000"}`
+	if actual := decodedJSON(t, registry.decodeInput(abiAddress(2), input)); actual != expected {
+		t.Errorf("contract ABI: expected %s, got %s", expected, actual)
+	}
+
+	expected = `transfer(address,uint256) {"to":"0x0000000000000000000000000000000000000003","value":"1000"}`
+	if actual := decodedJSON(t, registry.decodeInput(abiAddress(9), input)); actual != expected {
+		t.Errorf("other contract: expected %s, got %s", expected, actual)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+
+	pbdeth "github.com/dfuse-io/doh/pb/dfuse/codecs/deth"
+)
+
+// ethTraceTree is a transaction trace with its calls nested under their
+// parent call, and the net balance change of each address it touched.
+type ethTraceTree struct {
+	Hash              string                 `json:"hash"`
+	BlockNum          uint64                 `json:"block_num,omitempty"`
+	Index             uint32                 `json:"index"`
+	Status            string                 `json:"status"`
+	From              string                 `json:"from"`
+	To                string                 `json:"to"`
+	Value             string                 `json:"value"`
+	GasUsed           uint64                 `json:"gas_used"`
+	GasLimit          uint64                 `json:"gas_limit"`
+	GasPrice          string                 `json:"gas_price"`
+	FailedCalls       int                    `json:"failed_calls"`
+	Calls             []*ethCallNode         `json:"calls"`
+	NetBalanceChanges []*ethNetBalanceChange `json:"net_balance_changes"`
+}
+
+// ethCallNode is a call of a trace tree, with the state changes it made
+// and the calls it made. `StateReverted` calls failed, or have a failed
+// parent, so their changes didn't apply.
+type ethCallNode struct {
+	Index          uint32                  `json:"index"`
+	CallType       string                  `json:"call_type"`
+	Caller         string                  `json:"caller"`
+	Address        string                  `json:"address"`
+	Value          string                  `json:"value,omitempty"`
+	GasLimit       uint64                  `json:"gas_limit"`
+	GasConsumed    uint64                  `json:"gas_consumed"`
+	Failed         bool                    `json:"failed,omitempty"`
+	Reverted       bool                    `json:"reverted,omitempty"`
+	FailureReason  string                  `json:"failure_reason,omitempty"`
+	StateReverted  bool                    `json:"state_reverted,omitempty"`
+	Input          string                  `json:"input,omitempty"`
+	DecodedInput   *ethDecoded             `json:"decoded_input,omitempty"`
+	ReturnData     string                  `json:"return_data,omitempty"`
+	Logs           []*ethLogNode           `json:"logs,omitempty"`
+	StorageChanges []*ethStorageChangeNode `json:"storage_changes,omitempty"`
+	BalanceChanges []*ethBalanceChangeNode `json:"balance_changes,omitempty"`
+	GasChanges     []*ethGasChangeNode     `json:"gas_changes,omitempty"`
+	Children       []*ethCallNode          `json:"children,omitempty"`
+
+	call *pbdeth.Call
+}
+
+type ethLogNode struct {
+	Index   uint32      `json:"index"`
+	Address string      `json:"address"`
+	Topics  []string    `json:"topics"`
+	Data    string      `json:"data,omitempty"`
+	Decoded *ethDecoded `json:"decoded,omitempty"`
+}
+
+type ethStorageChangeNode struct {
+	Address  string `json:"address"`
+	Key      string `json:"key"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+type ethBalanceChangeNode struct {
+	Address  string `json:"address"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	Reason   string `json:"reason"`
+}
+
+type ethGasChangeNode struct {
+	OldValue uint64 `json:"old_value"`
+	NewValue uint64 `json:"new_value"`
+	Reason   string `json:"reason"`
+}
+
+// ethNetBalanceChange sums the balance changes of an address over a trace,
+// `Delta` being signed wei.
+type ethNetBalanceChange struct {
+	Address string `json:"address"`
+	Delta   string `json:"delta"`
+	Changes int    `json:"changes"`
+}
+
+var ethTraceTreesOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "hash", path: "hash"},
+		{header: "block_num", path: "block_num", missing: "?"},
+		{header: "status", path: "status"},
+		{header: "from", path: "from"},
+		{header: "to", path: "to"},
+		{header: "value", path: "value"},
+		{header: "gas_used", path: "gas_used"},
+		{header: "failed_calls", path: "failed_calls"},
+		{header: "balance_changes", path: "net_balance_changes.#"},
+	},
+}
+
+// Balance changes paying for gas apply even when the calls they're
+// attached to failed.
+var ethGasBalanceChangeReasons = map[pbdeth.BalanceChange_Reason]bool{
+	pbdeth.BalanceChange_REASON_GAS_BUY:                true,
+	pbdeth.BalanceChange_REASON_GAS_REFUND:             true,
+	pbdeth.BalanceChange_REASON_REWARD_TRANSACTION_FEE: true,
+}
+
+// nestETHCalls nests calls under their `ParentIndex` one, falling back on
+// `Depth` when the parent is not part of the trace.
+func nestETHCalls(calls []*pbdeth.Call) (roots []*ethCallNode) {
+	byIndex := map[uint32]*ethCallNode{}
+	var lastAtDepth []*ethCallNode
+
+	for _, call := range calls {
+		node := newETHCallNode(call)
+		byIndex[call.Index] = node
+
+		parent := byIndex[call.ParentIndex]
+		if call.ParentIndex == call.Index {
+			parent = nil
+		}
+		if parent == nil && call.Depth > 0 && int(call.Depth) <= len(lastAtDepth) {
+			parent = lastAtDepth[call.Depth-1]
+		}
+
+		if int(call.Depth) < len(lastAtDepth) {
+			lastAtDepth = lastAtDepth[:call.Depth]
+		}
+		for len(lastAtDepth) < int(call.Depth) {
+			lastAtDepth = append(lastAtDepth, nil)
+		}
+		lastAtDepth = append(lastAtDepth, node)
+
+		if parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+		node.StateReverted = node.StateReverted || parent.StateReverted
+		parent.Children = append(parent.Children, node)
+	}
+	return roots
+}
+
+func newETHCallNode(call *pbdeth.Call) *ethCallNode {
+	node := &ethCallNode{
+		Index:         call.Index,
+		CallType:      call.CallType.String(),
+		Caller:        fmt.Sprintf("0x%x", call.Caller),
+		Address:       fmt.Sprintf("0x%x", call.Address),
+		GasLimit:      call.GasLimit,
+		GasConsumed:   call.GasConsumed,
+		Failed:        call.Failed,
+		Reverted:      call.Reverted,
+		FailureReason: call.FailureReason,
+		StateReverted: call.Failed || call.Reverted,
+		call:          call,
+	}
+	if value := ethBigInt(call.Value); value != "0" {
+		node.Value = value
+	}
+	if len(call.Input) != 0 {
+		node.Input = fmt.Sprintf("0x%x", call.Input)
+		node.DecodedInput = ethDecodeInput(call)
+	}
+	if len(call.ReturnData) != 0 {
+		node.ReturnData = fmt.Sprintf("0x%x", call.ReturnData)
+	}
+
+	for _, log := range call.Logs {
+		logNode := &ethLogNode{Index: log.Index, Address: fmt.Sprintf("0x%x", log.Address), Topics: []string{}}
+		for _, topic := range log.Topics {
+			logNode.Topics = append(logNode.Topics, fmt.Sprintf("0x%x", topic))
+		}
+		if len(log.Data) != 0 {
+			logNode.Data = fmt.Sprintf("0x%x", log.Data)
+		}
+		logNode.Decoded = ethABIs.decodeLog(log.Address, log.Topics, log.Data)
+		node.Logs = append(node.Logs, logNode)
+	}
+	for _, change := range call.StorageChanges {
+		node.StorageChanges = append(node.StorageChanges, &ethStorageChangeNode{
+			Address:  fmt.Sprintf("0x%x", change.Address),
+			Key:      fmt.Sprintf("0x%x", change.Key),
+			OldValue: fmt.Sprintf("0x%x", change.OldValue),
+			NewValue: fmt.Sprintf("0x%x", change.NewValue),
+		})
+	}
+	for _, change := range call.BalanceChanges {
+		node.BalanceChanges = append(node.BalanceChanges, &ethBalanceChangeNode{
+			Address:  fmt.Sprintf("0x%x", change.Address),
+			OldValue: ethBigInt(change.OldValue),
+			NewValue: ethBigInt(change.NewValue),
+			Reason:   trimEnumPrefix(change.Reason, "REASON_"),
+		})
+	}
+	for _, change := range call.GasChanges {
+		node.GasChanges = append(node.GasChanges, &ethGasChangeNode{
+			OldValue: change.OldValue,
+			NewValue: change.NewValue,
+			Reason:   trimEnumPrefix(change.Reason, "REASON_"),
+		})
+	}
+	return node
+}
+
+// ethDecodeInput decodes the input of calls, creations' being code
+func ethDecodeInput(call *pbdeth.Call) *ethDecoded {
+	if call.CallType == pbdeth.CallType_CREATE {
+		return nil
+	}
+	return ethABIs.decodeInput(call.Address, call.Input)
+}
+
+// ethNetBalanceChanges sums the balance changes of each address, in order
+// of first change, leaving out the ones of reverted calls except for gas.
+func ethNetBalanceChanges(calls []*pbdeth.Call) (out []*ethNetBalanceChange) {
+	deltas := map[string]*big.Int{}
+	byAddress := map[string]*ethNetBalanceChange{}
+
+	var walk func(nodes []*ethCallNode)
+	walk = func(nodes []*ethCallNode) {
+		for _, node := range nodes {
+			for _, change := range node.call.BalanceChanges {
+				if node.StateReverted && !ethGasBalanceChangeReasons[change.Reason] {
+					continue
+				}
+
+				address := fmt.Sprintf("0x%x", change.Address)
+				if byAddress[address] == nil {
+					byAddress[address] = &ethNetBalanceChange{Address: address}
+					deltas[address] = new(big.Int)
+					out = append(out, byAddress[address])
+				}
+
+				delta := new(big.Int).Sub(ethBigIntValue(change.NewValue), ethBigIntValue(change.OldValue))
+				deltas[address].Add(deltas[address], delta)
+				byAddress[address].Changes++
+			}
+			walk(node.Children)
+		}
+	}
+	walk(nestETHCalls(calls))
+
+	for _, change := range out {
+		change.Delta = deltas[change.Address].String()
+		if deltas[change.Address].Sign() > 0 {
+			change.Delta = "+" + change.Delta
+		}
+	}
+	return out
+}
+
+func ethBigIntValue(value *pbdeth.BigInt) *big.Int {
+	if value == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).SetBytes(value.Bytes)
+}
+
+// newETHTraceTree builds the tree of `trace`, part of block `blockNum`
+// when known (0 otherwise, traces don't hold it).
+func newETHTraceTree(trace *pbdeth.TransactionTrace, blockNum uint64) *ethTraceTree {
+	tree := &ethTraceTree{
+		Hash:              fmt.Sprintf("0x%x", trace.Hash),
+		BlockNum:          blockNum,
+		Index:             trace.Index,
+		Status:            trace.Status.String(),
+		From:              fmt.Sprintf("0x%x", trace.From),
+		To:                fmt.Sprintf("0x%x", trace.To),
+		Value:             ethBigInt(trace.Value),
+		GasUsed:           trace.GasUsed,
+		GasLimit:          trace.GasLimit,
+		GasPrice:          ethBigInt(trace.GasPrice),
+		Calls:             nestETHCalls(trace.Calls),
+		NetBalanceChanges: ethNetBalanceChanges(trace.Calls),
+	}
+	for _, call := range trace.Calls {
+		if call.Failed {
+			tree.FailedCalls++
+		}
+	}
+	return tree
+}
+
+// textTree lays the tree out for `writeTextTree`, net balance changes
+// after the calls.
+func (t *ethTraceTree) textTree(trace *pbdeth.TransactionTrace) *blockTreeNode {
+	label := fmt.Sprintf("trx %s %s", t.Hash, t.Status)
+	if t.BlockNum != 0 {
+		label += fmt.Sprintf(" (block %d)", t.BlockNum)
+	}
+	root := newTreeNode("%s, %s -> %s, value %s wei, gas %d / %d", label, t.From, t.To, t.Value, t.GasUsed, t.GasLimit)
+	root.add(ethCallTrees(trace.Calls)...)
+
+	var netChanges []*blockTreeNode
+	for _, change := range t.NetBalanceChanges {
+		netChanges = append(netChanges, newTreeNode("%s %s wei (%d changes)", change.Address, change.Delta, change.Changes))
+	}
+	root.addList("net balance changes", netChanges)
+	return root
+}
+
+// writeETHTraceTrees writes the trees of `traces` in `mode`, records going
+// through `output` in `json` mode.
+func writeETHTraceTrees(mode string, output *recordWriter, blockNum uint64, traces []*pbdeth.TransactionTrace) error {
+	for _, trace := range traces {
+		tree := newETHTraceTree(trace, blockNum)
+		if mode == "text" {
+			writeTextTree(os.Stdout, tree.textTree(trace))
+			continue
+		}
+		if err := output.write(tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,22 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
-	"encoding/json"
+	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/dfuse-io/doh/fluxdb"
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
 	"github.com/dfuse-io/dstore"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func viewFluxShard(cmd *cobra.Command, args []string) (err error) {
 	// Take the first param, use as filename, read as zstd
 	baseFile := filepath.Base(args[0])
-	storeURL := strings.TrimSuffix(args[0], baseFile)
+	storeURL := strings.TrimSuffix(strings.TrimSuffix(args[0], baseFile), "/")
+	if storeURL == "" {
+		storeURL = "."
+	}
 	store, err := dstore.NewStore(storeURL, "shard.zst", "zstd", false)
 	if err != nil {
 		return err
@@ -28,8 +34,17 @@ func viewFluxShard(cmd *cobra.Command, args []string) (err error) {
 	}
 	defer read.Close()
 
+	blockFilter, err := fluxBlockFilter()
+	if err != nil {
+		return err
+	}
+
+	output, err := newRecordWriter(fluxRowsOutputSpec)
+	if err != nil {
+		return err
+	}
+
 	decoder := gob.NewDecoder(read)
-	encoder := json.NewEncoder(os.Stdout)
 	for {
 		req := new(fluxdb.WriteRequest)
 		err := decoder.Decode(&req)
@@ -39,9 +54,61 @@ func viewFluxShard(cmd *cobra.Command, args []string) (err error) {
 		if err != nil {
 			return err
 		}
-		if err := encoder.Encode(req); err != nil {
+		if !blockFilter.keep(uint64(req.BlockNum), time.Time{}) {
+			continue
+		}
+		if err := output.write(req); err != nil {
 			return err
 		}
 	}
-	return nil
+	return output.close()
+}
+
+// Flux rows are summarized as counts in `csv` and `table` output
+var fluxRowsOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "block_num", path: "BlockNum"},
+		{header: "block_id", path: "BlockID"},
+		{header: "abis", path: "ABIs.#", missing: "0"},
+		{header: "auth_links", path: "AuthLinks.#", missing: "0"},
+		{header: "key_accounts", path: "KeyAccounts.#", missing: "0"},
+		{header: "table_datas", path: "TableDatas.#", missing: "0"},
+		{header: "table_scopes", path: "TableScopes.#", missing: "0"},
+	},
+}
+
+// fluxBlockFilter builds the `--ts-start` / `--ts-end` filter, write
+// requests only having a block number, times are first resolved to blocks
+// through the timeline table.
+func fluxBlockFilter() (*blockRangeFilter, error) {
+	filter, err := newBlockRangeFilter("flux-cmd", pbbstream.Protocol_EOS)
+	if err != nil || !filter.hasTimes() {
+		return filter, err
+	}
+
+	timelineTable := viper.GetString("flux-cmd-timeline-table")
+	if timelineTable == "" {
+		return nil, fmt.Errorf("time bounds need --timeline-table to be resolved to blocks, or use block:NUM")
+	}
+	if protocol, err := btTableProtocol("flux-cmd", timelineTable); err != nil || protocol != pbbstream.Protocol_EOS {
+		return nil, fmt.Errorf("--timeline-table %q is not an EOS timeline table", timelineTable)
+	}
+
+	project, instance, err := resolveDb(viper.GetString("flux-cmd-db"))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newBigTableClient(project, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	table := client.Open(timelineTable)
+	err = filter.resolveTimes(func(tm time.Time) (uint64, error) {
+		return btTimelineBlockNum(context.Background(), table, tm)
+	})
+	return filter, err
 }
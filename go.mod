@@ -1,11 +1,10 @@
 module github.com/dfuse-io/doh
 
-go 1.12
+go 1.18
 
 require (
 	cloud.google.com/go v0.51.0
 	cloud.google.com/go/bigtable v1.2.0
-	contrib.go.opencensus.io/exporter/stackdriver v0.13.1 // indirect
 	github.com/abourget/viperbind v0.1.0
 	github.com/coreos/etcd v3.3.12+incompatible
 	github.com/dfuse-io/bstream v0.0.0-20200407175946-02835b21c627
@@ -13,20 +12,86 @@ require (
 	github.com/dfuse-io/dstore v0.0.0-20200407173215-10b5ced43022
 	github.com/dfuse-io/jsonpb v0.0.0-20200406211248-c5cf83f0e0c0
 	github.com/dfuse-io/kvdb v0.0.0-20200407191956-e3308ad697fc
+	github.com/dgraph-io/badger/v2 v2.0.3
 	github.com/dustin/go-humanize v1.0.0
-	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
 	github.com/golang/protobuf v1.3.5
-	github.com/jonboulle/clockwork v0.1.0 // indirect
-	github.com/klauspost/compress v1.10.2
+	github.com/klauspost/compress v1.17.0
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.3.2
 	github.com/tcnksm/go-gitconfig v0.1.2
+	github.com/tidwall/gjson v1.5.0
 	github.com/tidwall/sjson v1.0.4
+	github.com/tikv/client-go v0.0.0-20200110101306-a3ebdb020c83
+	golang.org/x/crypto v0.0.0-20191206172530-e9b2fee46413
+	google.golang.org/api v0.15.0
+	gopkg.in/src-d/go-git.v4 v4.13.1
+	gopkg.in/yaml.v2 v2.2.3
+)
+
+require (
+	cloud.google.com/go/storage v1.5.0 // indirect
+	contrib.go.opencensus.io/exporter/stackdriver v0.13.1 // indirect
+	github.com/DataDog/zstd v1.4.1 // indirect
+	github.com/aws/aws-sdk-go v1.25.43 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/blendle/zapdriver v1.3.1 // indirect
+	github.com/cespare/xxhash v1.1.0 // indirect
+	github.com/dfuse-io/logging v0.0.0-20200407175011-14021b7a79af // indirect
+	github.com/dgraph-io/ristretto v0.0.2-0.20200115201040-8f368f2f2ab3 // indirect
+	github.com/dgryski/go-farm v0.0.0-20190423205320-6a90982ecee2 // indirect
+	github.com/emirpasic/gods v1.12.0 // indirect
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/gogo/protobuf v1.3.1 // indirect
+	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/google/btree v1.0.0 // indirect
+	github.com/google/go-cmp v0.4.0 // indirect
+	github.com/googleapis/gax-go/v2 v2.0.5 // indirect
+	github.com/grpc-ecosystem/go-grpc-middleware v1.0.1-0.20190717153623-606c73359dba // indirect
+	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/jonboulle/clockwork v0.1.0 // indirect
+	github.com/kevinburke/ssh_config v0.0.0-20190725054713-01f96b0aa0cd // indirect
+	github.com/magiconair/properties v1.8.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/mitchellh/go-homedir v1.1.0 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
+	github.com/opentracing/opentracing-go v1.1.0 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/pingcap/kvproto v0.0.0-20200403035933-b4034bceab26 // indirect
+	github.com/pingcap/pd v2.1.5+incompatible // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_golang v1.1.0 // indirect
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
+	github.com/prometheus/common v0.6.0 // indirect
+	github.com/prometheus/procfs v0.0.3 // indirect
+	github.com/sergi/go-diff v1.0.1-0.20180205163309-da645544ed44 // indirect
+	github.com/sirupsen/logrus v1.4.2 // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.3.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/src-d/gcfg v1.4.0 // indirect
+	github.com/teris-io/shortid v0.0.0-20171029131806-771a37caa5cf // indirect
+	github.com/tidwall/match v1.0.1 // indirect
+	github.com/tidwall/pretty v1.0.0 // indirect
+	github.com/xanzy/ssh-agent v0.2.1 // indirect
 	go.opencensus.io v0.22.3 // indirect
+	go.uber.org/atomic v1.6.0 // indirect
+	go.uber.org/multierr v1.5.0 // indirect
 	go.uber.org/zap v1.14.1 // indirect
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
+	golang.org/x/net v0.0.0-20200226121028-0de0cce0169b // indirect
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d // indirect
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
+	golang.org/x/text v0.3.2 // indirect
 	golang.org/x/tools v0.0.0-20200409210453-700752c24408 // indirect
-	google.golang.org/api v0.15.0
-	gopkg.in/src-d/go-git.v4 v4.13.1
+	google.golang.org/genproto v0.0.0-20200108215221-bd8f9a0ef82f // indirect
+	google.golang.org/grpc v1.26.0 // indirect
+	gopkg.in/src-d/go-billy.v4 v4.3.2 // indirect
+	gopkg.in/warnings.v0 v0.1.2 // indirect
 	honnef.co/go/tools v0.0.1-2020.1.3 // indirect
+	rsc.io/binaryregexp v0.2.0 // indirect
 )
@@ -1,24 +1,52 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/dfuse-io/kvdb"
 	"github.com/dfuse-io/kvdb/store"
 	_ "github.com/dfuse-io/kvdb/store/badger"
-	_ "github.com/dfuse-io/kvdb/store/tikv"
 	_ "github.com/dfuse-io/kvdb/store/bigkv"
+	_ "github.com/dfuse-io/kvdb/store/tikv"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var kvCmd = &cobra.Command{Use: "kv", Short: "Read from a KVStore"}
-var kvPrefixCmd = &cobra.Command{Use: "prefix", Short: "prefix read from KVStore", RunE: kvPrefix, Args: cobra.ExactArgs(1)}
-var kvScanCmd = &cobra.Command{Use: "scan", Short: "scan read from KVStore", RunE: kvScan, Args: cobra.ExactArgs(2)}
-var kvGetCmd = &cobra.Command{Use: "get", Short: "get key from KVStore", RunE: kvGet, Args: cobra.ExactArgs(1)}
+var kvCmd = &cobra.Command{Use: "kv", Short: "Read from and write to a KVStore"}
+var kvPrefixCmd = &cobra.Command{Use: "prefix [prefix]", Short: "prefix read from KVStore", Long: kvKeyExprHelp, RunE: kvPrefix, Args: cobra.ExactArgs(1)}
+var kvScanCmd = &cobra.Command{Use: "scan [start] [exclusive-end]", Short: "scan read from KVStore", Long: kvKeyExprHelp, RunE: kvScan, Args: cobra.ExactArgs(2)}
+var kvGetCmd = &cobra.Command{Use: "get [key]...", Short: "get keys from KVStore, from args or one per line on stdin (exit code 3 when some are not found, 1 on errors)", Long: kvKeyExprHelp, RunE: kvGet}
+var kvPutCmd = &cobra.Command{Use: "put [key] [value]", Short: "write a single key to KVStore, value is also a key expression", Long: kvKeyExprHelp, RunE: kvPut, Args: cobra.ExactArgs(2)}
+var kvDeleteCmd = &cobra.Command{Use: "delete [key]", Short: "delete a key, or all keys under a prefix with --prefix, from KVStore", Long: kvKeyExprHelp, RunE: kvDelete, Args: cobra.ExactArgs(1)}
+var kvImportCmd = &cobra.Command{Use: "import [file.jsonl]", Short: "write keys from a JSONL file ('-' for stdin), as produced by 'export'", Long: kvImportHelp, RunE: kvImport, Args: cobra.ExactArgs(1)}
+var kvCopyCmd = &cobra.Command{Use: "copy", Short: "copy keys from one KVStore to another (badger, tikv, bigkv), resumable through --checkpoint", RunE: kvCopy, Args: cobra.NoArgs}
+var kvStatsCmd = &cobra.Command{Use: "stats [prefix]", Short: "key count and sizes, grouped by key prefix, for all keys under [prefix]", Long: kvKeyExprHelp, RunE: kvStats, Args: cobra.MaximumNArgs(1)}
+var kvExportCmd = &cobra.Command{Use: "export [prefix]", Short: "dump keys under a prefix as JSONL, in the format read by 'import'", Long: kvKeyExprHelp, RunE: kvExport, Args: cobra.ExactArgs(1)}
+
+const kvKeyExprHelp = `Keys are key expressions, a '+' separated list of terms concatenated together:
+
+    doh kv prefix 'blk: + u64be(12345) + name(eosio.token)'
+    doh kv get 0x0100ff
+
+Terms:
+    0x<hex>, hex(<hex>)        raw bytes
+    "text", 'text', text       ASCII bytes (quote to use '+' or spaces)
+    u8(n), u16be(n), u32be(n), u64be(n), u16le(n), u32le(n), u64le(n)
+    name(<eos name>)           EOS name, as a big-endian uint64
+
+Use '--key-format expr' to print keys back in that syntax.`
+
+const kvImportHelp = `Each line of the input is a JSON object with a "key" and a "value", both
+being key expressions (see 'doh kv get --help'):
+
+    {"key": "0x626c6b3a", "value": "0x0a0b0c"}
+    {"key": "blk: + u64be(12345)", "value": "hello"}`
 
 func init() {
 	rootCmd.AddCommand(kvCmd)
@@ -26,82 +54,335 @@ func init() {
 	kvCmd.AddCommand(kvPrefixCmd)
 	kvCmd.AddCommand(kvScanCmd)
 	kvCmd.AddCommand(kvGetCmd)
+	kvCmd.AddCommand(kvPutCmd)
+	kvCmd.AddCommand(kvDeleteCmd)
+	kvCmd.AddCommand(kvImportCmd)
+	kvCmd.AddCommand(kvExportCmd)
+	kvCmd.AddCommand(kvCopyCmd)
+	kvCmd.AddCommand(kvStatsCmd)
 
-	kvCmd.PersistentFlags().StringP("store", "s", "badger:///dfusebox-data/kvdb/kvdb_badger.db", "KVStore DSN")
+	kvCmd.PersistentFlags().StringP("store", "s", "", "KVStore DSN, defaults to the --profile one, or "+defaultKVStore)
 	kvCmd.PersistentFlags().IntP("depth", "d", 1, "Depth of decoding. 0 = top-level block, 1 = kind-specific blocks, 2 = future!")
 	kvCmd.PersistentFlags().StringP("protocol", "p", "", "block protocol value to assume of the data")
+	kvCmd.PersistentFlags().String("key-format", "hex", "How to print keys, one of: hex, expr (key expression syntax)")
+	kvCmd.PersistentFlags().Bool("decompress", true, "Decompress zstd and gzip values when reading (prefix, scan, get), naming the codec after the value")
+	kvCmd.PersistentFlags().StringSlice("zstd-dict", nil, "zstd dictionary files for dictionary-compressed values (repeatable)")
 
 	kvScanCmd.Flags().IntP("limit", "l", 100, "limit the number of rows when doing scan")
+
+	kvDeleteCmd.Flags().Bool("prefix", false, "Delete all keys starting with [key] instead of the single key")
+	kvDeleteCmd.Flags().Bool("dry-run", false, "Only count the keys that would be deleted")
+
+	kvImportCmd.Flags().Int("batch-size", 1000, "Number of puts between forced flushes")
+
+	kvStatsCmd.Flags().Int("group-bytes", 1, "Group keys by their first N bytes")
+	kvStatsCmd.Flags().StringSlice("group", nil, "Group keys by these prefixes (key expressions, repeatable) instead of --group-bytes")
+	kvStatsCmd.Flags().Int("top", 10, "Number of largest keys to report, as (largest) group records")
+
+	kvCopyCmd.Flags().String("from", "", "Source KVStore DSN, defaults to --store")
+	kvCopyCmd.Flags().String("to", "", "Destination KVStore DSN")
+	kvCopyCmd.Flags().String("prefix", "", "Only copy keys under this prefix (key expression)")
+	kvCopyCmd.Flags().Int("batch-size", 1000, "Number of keys read and flushed at once")
+	kvCopyCmd.Flags().String("checkpoint", "", "File where progress is saved after each batch, and resumed from when it exists")
 }
 
 func kvPrefix(cmd *cobra.Command, args []string) (err error) {
-	kv, err := store.New(viper.GetString("kv-cmd-store"))
+	kv, err := newKVStore()
 	if err != nil {
 		return err
 	}
+	defer closeKVStore(kv)
 
-	prefix, err := hex.DecodeString(args[0])
+	prefix, err := parseKeyExpr(args[0])
 	if err != nil {
 		return fmt.Errorf("error decoding prefix %q: %s", args[0], err)
 	}
+	if err := kvConfirmScan(prefix); err != nil {
+		return err
+	}
+	decompressor, err := newKVDecompressor()
+	if err != nil {
+		return err
+	}
+
+	output, err := newRecordWriter(kvItemsOutputSpec)
+	if err != nil {
+		return err
+	}
+
 	it := kv.Prefix(context.Background(), prefix)
 	for it.Next() {
 		item := it.Item()
-		fmt.Println(hex.EncodeToString(item.Key), hex.EncodeToString(item.Value))
+		if err := output.write(newKVItemRecord(decompressor, item.Key, item.Value)); err != nil {
+			return err
+		}
 	}
 	if err := it.Err(); err != nil {
 		return err
 	}
 
-	return nil
+	return output.close()
 }
 
 func kvScan(cmd *cobra.Command, args []string) (err error) {
-	kv, err := store.New(viper.GetString("kv-cmd-store"))
+	kv, err := newKVStore()
 	if err != nil {
 		return err
 	}
+	defer closeKVStore(kv)
 
-	start, err := hex.DecodeString(args[0])
+	start, err := parseKeyExpr(args[0])
 	if err != nil {
 		return fmt.Errorf("error decoding range start %q: %s", args[0], err)
 	}
-	end, err := hex.DecodeString(args[1])
+	end, err := parseKeyExpr(args[1])
 	if err != nil {
 		return fmt.Errorf("error decoding range end %q: %s", args[1], err)
 	}
 
 	limit := viper.GetInt("kv-scan-cmd-limit")
 
+	decompressor, err := newKVDecompressor()
+	if err != nil {
+		return err
+	}
+
+	output, err := newRecordWriter(kvItemsOutputSpec)
+	if err != nil {
+		return err
+	}
+
 	it := kv.Scan(context.Background(), start, end, limit)
 	for it.Next() {
 		item := it.Item()
-		fmt.Println(hex.EncodeToString(item.Key), hex.EncodeToString(item.Value))
+		if err := output.write(newKVItemRecord(decompressor, item.Key, item.Value)); err != nil {
+			return err
+		}
 	}
 	if err := it.Err(); err != nil {
 		return err
 	}
 
-	return nil
+	return output.close()
+}
+
+var kvGetOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "key", path: "key"},
+		{header: "found", path: "found"},
+		{header: "value", path: "value"},
+		{header: "compressed", path: "_compressed"},
+	},
+}
+
+// kvGetResult is one record of `doh kv get` output
+type kvGetResult struct {
+	Key        string `json:"key"`
+	Found      bool   `json:"found"`
+	Value      string `json:"value,omitempty"`
+	Compressed string `json:"_compressed,omitempty"`
 }
 
 func kvGet(cmd *cobra.Command, args []string) (err error) {
-	kv, err := store.New(viper.GetString("kv-cmd-store"))
+	var keyExprs []string
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				keyExprs = append(keyExprs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading keys from stdin: %s", err)
+		}
+	} else {
+		keyExprs = args
+	}
+
+	keys := make([][]byte, len(keyExprs))
+	for i, expr := range keyExprs {
+		keys[i], err = parseKeyExpr(expr)
+		if err != nil {
+			return fmt.Errorf("error decoding key %q: %s", expr, err)
+		}
+	}
+
+	kv, err := newKVStore()
 	if err != nil {
 		return err
 	}
+	defer closeKVStore(kv)
 
-	key, err := hex.DecodeString(args[0])
+	values, err := kvBatchGet(context.Background(), kv, keys)
 	if err != nil {
-		return fmt.Errorf("error decoding range start %q: %s", args[0], err)
+		return err
+	}
+
+	decompressor, err := newKVDecompressor()
+	if err != nil {
+		return err
 	}
 
-	val, err := kv.Get(context.Background(), key)
-	if err == kvdb.ErrNotFound {
-		os.Exit(1)
+	output, err := newRecordWriter(kvGetOutputSpec)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println(hex.EncodeToString(key), hex.EncodeToString(val))
+	notFound := 0
+	for i, key := range keys {
+		result := kvGetResult{Key: formatKey(key)}
+		if values[i] == nil {
+			notFound++
+		} else {
+			value, codec := decompressKVValue(decompressor, key, values[i])
+			result.Found = true
+			result.Value = hex.EncodeToString(value)
+			result.Compressed = codec
+		}
+
+		if err := output.write(result); err != nil {
+			return err
+		}
+	}
+	if err := output.close(); err != nil {
+		return err
+	}
+
+	if notFound != 0 {
+		return &exitError{code: exitCodeNotFound, err: fmt.Errorf("%d of %d keys not found", notFound, len(keys))}
+	}
 
 	return nil
 }
+
+// kvBatchGet returns the values of `keys`, in order, with a `nil` value
+// for keys that don't exist. The stores' `BatchGet` stops at the first
+// missing key, so when that happens we fall back to individual gets.
+func kvBatchGet(ctx context.Context, kv store.KVStore, keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, 0, len(keys))
+
+	it := kv.BatchGet(ctx, keys)
+	for it.Next() {
+		// Every key found here exists, badger returning empty values as nil
+		value := it.Item().Value
+		if value == nil {
+			value = []byte{}
+		}
+		values = append(values, value)
+	}
+	it.Close()
+
+	if it.Err() == nil && len(values) == len(keys) {
+		return values, nil
+	}
+
+	values = make([][]byte, len(keys))
+	for i, key := range keys {
+		val, err := kv.Get(ctx, key)
+		if isKVNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get key %x: %s", key, err)
+		}
+
+		if val == nil {
+			val = []byte{}
+		}
+		values[i] = val
+	}
+
+	return values, nil
+}
+
+func isKVNotFound(err error) bool {
+	return err == store.ErrNotFound || err == kvdb.ErrNotFound
+}
+
+const defaultKVStore = "badger:///dfusebox-data/kvdb/kvdb_badger.db"
+
+func newKVStore() (store.KVStore, error) {
+	dsn, err := kvStoreDSN()
+	if err != nil {
+		return nil, err
+	}
+	return store.New(dsn)
+}
+
+// kvStoreDSN is `--store`, or the active profile's kv DSN, announced on stderr
+func kvStoreDSN() (string, error) {
+	dsn := viper.GetString("kv-global-store")
+	if dsn == "" {
+		var err error
+		if dsn, err = profileKVStore(); err != nil {
+			return "", err
+		}
+	}
+	if dsn == "" {
+		dsn = defaultKVStore
+	}
+
+	announceTarget(kvTarget(dsn))
+	return dsn, nil
+}
+
+// closeKVStore closes the store when the driver supports it, which is
+// required for some (like badger) to release locks and persist writes.
+func closeKVStore(kv store.KVStore) error {
+	if closer, ok := kv.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// newKVDecompressor returns nil when `--decompress` is off
+func newKVDecompressor() (*valueDecompressor, error) {
+	if !viper.GetBool("kv-global-decompress") {
+		return nil, nil
+	}
+	return newValueDecompressor(viper.GetStringSlice("kv-global-zstd-dict"))
+}
+
+// decompressKVValue returns the value as is when it can't be decompressed
+func decompressKVValue(decompressor *valueDecompressor, key, value []byte) ([]byte, string) {
+	if decompressor == nil {
+		return value, ""
+	}
+
+	decompressed, codec, err := decompressor.decompress(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key %s: value looks compressed but isn't, showing it as is: %s\n", formatKey(key), err)
+		return value, ""
+	}
+	return decompressed, codec
+}
+
+// kvItemRecord is a key and its hex value, with the codec when the value
+// was decompressed.
+type kvItemRecord struct {
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	Compressed string `json:"_compressed,omitempty"`
+}
+
+var kvItemsOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "key", path: "key", whole: true},
+		{header: "value", path: "value", whole: true},
+		{header: "compressed", path: "_compressed"},
+	},
+}
+
+func newKVItemRecord(decompressor *valueDecompressor, key, value []byte) kvItemRecord {
+	value, codec := decompressKVValue(decompressor, key, value)
+	return kvItemRecord{Key: formatKey(key), Value: hex.EncodeToString(value), Compressed: codec}
+}
+
+func formatKey(key []byte) string {
+	if viper.GetString("kv-global-key-format") == "expr" {
+		return formatKeyExpr(key)
+	}
+	return hex.EncodeToString(key)
+}
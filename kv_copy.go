@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dfuse-io/kvdb/store"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// kvCopyCheckpoint is the progress of a copy, only resumed by the same
+// copy: same source, destination and prefix.
+type kvCopyCheckpoint struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Prefix  string `json:"prefix"`
+	LastKey string `json:"last_key"`
+	Keys    uint64 `json:"keys"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+func kvCopy(cmd *cobra.Command, args []string) (err error) {
+	fromDSN := viper.GetString("kv-copy-cmd-from")
+	if fromDSN == "" {
+		if fromDSN, err = kvStoreDSN(); err != nil {
+			return err
+		}
+	}
+	toDSN := viper.GetString("kv-copy-cmd-to")
+	if toDSN == "" {
+		return fmt.Errorf("--to is required")
+	}
+	if fromDSN == toDSN {
+		return fmt.Errorf("--from and --to are the same store")
+	}
+
+	prefix, err := parseKeyExpr(viper.GetString("kv-copy-cmd-prefix"))
+	if err != nil {
+		return fmt.Errorf("error decoding prefix: %s", err)
+	}
+
+	fromTarget, toTarget := kvTarget(fromDSN), kvTarget(toDSN)
+	announceTarget(fromTarget)
+	announceTarget(toTarget)
+	if len(prefix) == 0 {
+		if err := confirmProd(fromTarget, "copy every key"); err != nil {
+			return err
+		}
+	}
+	if err := confirmProd(toTarget, "copy keys to it"); err != nil {
+		return err
+	}
+
+	checkpointFile := viper.GetString("kv-copy-cmd-checkpoint")
+	checkpoint, err := readKVCopyCheckpoint(checkpointFile)
+	if err != nil {
+		return err
+	}
+
+	current := &kvCopyCheckpoint{From: fromDSN, To: toDSN, Prefix: hex.EncodeToString(prefix)}
+	if checkpoint.LastKey != "" && (checkpoint.From != current.From || checkpoint.To != current.To || checkpoint.Prefix != current.Prefix) {
+		return fmt.Errorf("checkpoint %q is for a copy from %q to %q of prefix %q, not from %q to %q of prefix %q, remove it to start over",
+			checkpointFile, checkpoint.From, checkpoint.To, checkpoint.Prefix, current.From, current.To, current.Prefix)
+	}
+	checkpoint.From, checkpoint.To, checkpoint.Prefix = current.From, current.To, current.Prefix
+
+	start := prefix
+	if checkpoint.LastKey != "" {
+		lastKey, err := hex.DecodeString(checkpoint.LastKey)
+		if err != nil {
+			return fmt.Errorf("invalid last key in checkpoint %q: %s", checkpointFile, err)
+		}
+		if !bytes.HasPrefix(lastKey, prefix) {
+			return fmt.Errorf("checkpoint %q last key %s is outside of prefix %x", checkpointFile, checkpoint.LastKey, prefix)
+		}
+
+		fmt.Fprintf(os.Stderr, "Resuming after key %s (%d keys already copied)\n", checkpoint.LastKey, checkpoint.Keys)
+		start = append(lastKey, 0x00)
+	}
+
+	end := prefixNext(prefix)
+	if end == nil {
+		// Backends treat an empty end as "nothing" or "max scan limit", be explicit
+		end = bytes.Repeat([]byte{0xff}, 64)
+	}
+
+	from, err := store.New(fromDSN)
+	if err != nil {
+		return fmt.Errorf("opening source store: %s", err)
+	}
+	defer closeKVStore(from)
+
+	to, err := store.New(toDSN)
+	if err != nil {
+		return fmt.Errorf("opening destination store: %s", err)
+	}
+	defer func() {
+		if closeErr := closeKVStore(to); err == nil && closeErr != nil {
+			err = fmt.Errorf("closing destination store: %s", closeErr)
+		}
+	}()
+
+	ctx := context.Background()
+	batchSize := viper.GetInt("kv-copy-cmd-batch-size")
+	if batchSize <= 0 {
+		return fmt.Errorf("--batch-size must be positive")
+	}
+
+	t0 := time.Now()
+	var copiedKeys uint64
+	for {
+		var lastKey []byte
+		count := 0
+
+		it := from.Scan(ctx, start, end, batchSize)
+		for it.Next() {
+			item := it.Item()
+			if err := to.Put(ctx, item.Key, item.Value); err != nil {
+				it.Close()
+				return fmt.Errorf("put key %x: %s", item.Key, err)
+			}
+
+			lastKey = item.Key
+			checkpoint.Bytes += uint64(len(item.Key) + len(item.Value))
+			count++
+		}
+		if err := it.Err(); err != nil {
+			return fmt.Errorf("scanning source: %s", err)
+		}
+
+		if count == 0 {
+			break
+		}
+
+		if err := to.FlushPuts(ctx); err != nil {
+			return fmt.Errorf("flush puts: %s", err)
+		}
+
+		copiedKeys += uint64(count)
+		checkpoint.Keys += uint64(count)
+		checkpoint.LastKey = hex.EncodeToString(lastKey)
+		if err := writeKVCopyCheckpoint(checkpointFile, checkpoint); err != nil {
+			return err
+		}
+
+		elapsed := time.Since(t0)
+		fmt.Fprintf(os.Stderr, "Copied %s keys (%s total), %.0f keys/s, last key %s\n",
+			humanize.Comma(int64(checkpoint.Keys)),
+			humanize.Bytes(checkpoint.Bytes),
+			float64(copiedKeys)/elapsed.Seconds(),
+			formatKey(lastKey),
+		)
+
+		if count < batchSize {
+			break
+		}
+		start = append(lastKey, 0x00)
+	}
+
+	fmt.Fprintf(os.Stderr, "Done, copied %s keys in %s\n", humanize.Comma(int64(copiedKeys)), time.Since(t0).Round(time.Millisecond))
+	return nil
+}
+
+func readKVCopyCheckpoint(filename string) (out *kvCopyCheckpoint, err error) {
+	out = &kvCopyCheckpoint{}
+	if filename == "" {
+		return
+	}
+
+	cnt, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %s", err)
+	}
+
+	if err := json.Unmarshal(cnt, out); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %q: %s", filename, err)
+	}
+	return out, nil
+}
+
+func writeKVCopyCheckpoint(filename string, checkpoint *kvCopyCheckpoint) error {
+	if filename == "" {
+		return nil
+	}
+
+	cnt, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so an interrupted copy never leaves a truncated checkpoint
+	if err := ioutil.WriteFile(filename+".tmp", cnt, 0644); err != nil {
+		return fmt.Errorf("writing checkpoint: %s", err)
+	}
+	return os.Rename(filename+".tmp", filename)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/tikv/client-go/config"
+	"github.com/tikv/client-go/key"
+	"github.com/tikv/client-go/rawkv"
+)
+
+// The `kvdb` store interface has no notion of deletion, so we talk to the
+// underlying backends directly, honoring the same DSN format (and the
+// `keyPrefix` parameter) as the `kvdb` drivers do.
+type kvDeleter interface {
+	// DeleteRange deletes all keys in `[start, exclusiveEnd)`, an empty
+	// `exclusiveEnd` means unbounded. When `dryRun` is set, keys are only
+	// counted.
+	DeleteRange(ctx context.Context, start, exclusiveEnd []byte, dryRun bool) (count int, err error)
+	Close() error
+}
+
+func newKVDeleter(dsnString string) (kvDeleter, error) {
+	dsn, err := url.Parse(dsnString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn %q: %s", dsnString, err)
+	}
+
+	keyPrefix, err := hex.DecodeString(dsn.Query().Get("keyPrefix"))
+	if err != nil {
+		return nil, fmt.Errorf("decoding keyPrefix as hex: %s", err)
+	}
+
+	switch dsn.Scheme {
+	case "badger":
+		db, err := badger.Open(badger.DefaultOptions(dsn.Path).WithLogger(nil).WithCompression(options.None))
+		if err != nil {
+			return nil, fmt.Errorf("open badger db: %s", err)
+		}
+		return &badgerDeleter{db: db}, nil
+
+	case "tikv":
+		chunks := strings.Split(dsn.Host, ":")
+		if len(chunks) != 2 {
+			return nil, fmt.Errorf("dsn %q invalid, ensure host component looks like 'pd0,pd1:port'", dsnString)
+		}
+
+		var hosts []string
+		for _, h := range strings.Split(chunks[0], ",") {
+			hosts = append(hosts, fmt.Sprintf("%s:%s", h, chunks[1]))
+		}
+
+		conf := config.Default()
+		client, err := rawkv.NewClient(context.Background(), hosts, conf)
+		if err != nil {
+			return nil, err
+		}
+		return &tikvDeleter{client: client, keyPrefix: keyPrefix, scanLimit: conf.Raw.MaxScanLimit}, nil
+
+	case "bigkv":
+		projInstance := strings.Split(dsn.Host, ".")
+		if len(projInstance) != 2 {
+			return nil, fmt.Errorf("dsn %q invalid, ensure host component looks like 'project.instance'", dsnString)
+		}
+
+		client, err := newBigTableClient(projInstance[0], projInstance[1])
+		if err != nil {
+			return nil, err
+		}
+		return &bigkvDeleter{client: client, table: client.Open(strings.Trim(dsn.Path, "/")), keyPrefix: keyPrefix}, nil
+	}
+
+	return nil, fmt.Errorf("deletion not supported on kv store %q", dsn.Scheme)
+}
+
+// prefixNext returns the smallest key greater than all keys having
+// `prefix`, or `nil` when there is none (unbounded).
+func prefixNext(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			out := append([]byte{}, prefix[:i+1]...)
+			out[i]++
+			return out
+		}
+	}
+	return nil
+}
+
+func withKeyPrefix(keyPrefix, k []byte) []byte {
+	if len(keyPrefix) == 0 {
+		return k
+	}
+	return append(append([]byte{}, keyPrefix...), k...)
+}
+
+type badgerDeleter struct {
+	db *badger.DB
+}
+
+func (d *badgerDeleter) DeleteRange(ctx context.Context, start, exclusiveEnd []byte, dryRun bool) (count int, err error) {
+	batch := d.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	err = d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(start); it.Valid(); it.Next() {
+			if len(exclusiveEnd) != 0 && bytes.Compare(it.Item().Key(), exclusiveEnd) >= 0 {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			count++
+			if !dryRun {
+				if err := batch.Delete(it.Item().KeyCopy(nil)); err != nil {
+					return fmt.Errorf("delete key %x: %s", it.Item().Key(), err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return count, err
+	}
+
+	return count, batch.Flush()
+}
+
+func (d *badgerDeleter) Close() error {
+	return d.db.Close()
+}
+
+type tikvDeleter struct {
+	client    *rawkv.Client
+	keyPrefix []byte
+	scanLimit int
+}
+
+func (d *tikvDeleter) DeleteRange(ctx context.Context, start, exclusiveEnd []byte, dryRun bool) (count int, err error) {
+	startKey := withKeyPrefix(d.keyPrefix, start)
+	endKey := withKeyPrefix(d.keyPrefix, exclusiveEnd)
+	if len(exclusiveEnd) == 0 && len(d.keyPrefix) != 0 {
+		endKey = prefixNext(d.keyPrefix)
+	}
+
+	for cursor := startKey; ; {
+		keys, _, err := d.client.Scan(ctx, cursor, endKey, d.scanLimit)
+		if err != nil {
+			return count, err
+		}
+
+		count += len(keys)
+		if len(keys) < d.scanLimit {
+			break
+		}
+		cursor = key.Key(keys[len(keys)-1]).Next()
+	}
+
+	if dryRun || count == 0 {
+		return count, nil
+	}
+
+	if len(endKey) == 0 {
+		// `DeleteRange` doesn't do unbounded ranges, 0xff... is as far as keys go in practice
+		endKey = bytes.Repeat([]byte{0xff}, 64)
+	}
+
+	return count, d.client.DeleteRange(ctx, startKey, endKey)
+}
+
+func (d *tikvDeleter) Close() error {
+	return d.client.Close()
+}
+
+type bigkvDeleter struct {
+	client    *bigtable.Client
+	table     *bigtable.Table
+	keyPrefix []byte
+}
+
+func (d *bigkvDeleter) DeleteRange(ctx context.Context, start, exclusiveEnd []byte, dryRun bool) (count int, err error) {
+	startKey := string(withKeyPrefix(d.keyPrefix, start))
+
+	var rowRange bigtable.RowRange
+	switch {
+	case len(exclusiveEnd) != 0:
+		rowRange = bigtable.NewRange(startKey, string(withKeyPrefix(d.keyPrefix, exclusiveEnd)))
+	case len(d.keyPrefix) != 0:
+		rowRange = bigtable.NewRange(startKey, string(prefixNext(d.keyPrefix)))
+	default:
+		rowRange = bigtable.InfiniteRange(startKey)
+	}
+
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		muts := make([]*bigtable.Mutation, len(batch))
+		for i := range batch {
+			muts[i] = bigtable.NewMutation()
+			muts[i].DeleteRow()
+		}
+
+		errs, err := d.table.ApplyBulk(ctx, batch, muts)
+		if err != nil {
+			return err
+		}
+		if len(errs) != 0 {
+			return fmt.Errorf("apply bulk error: %s", errs)
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	var innerErr error
+	err = d.table.ReadRows(ctx, rowRange, func(row bigtable.Row) bool {
+		count++
+		if dryRun {
+			return true
+		}
+
+		batch = append(batch, row.Key())
+		if len(batch) >= 1000 {
+			innerErr = flush()
+		}
+		return innerErr == nil
+	}, bigtable.RowFilter(bigtable.StripValueFilter()))
+	if err != nil {
+		return count, err
+	}
+	if innerErr != nil {
+		return count, innerErr
+	}
+
+	return count, flush()
+}
+
+func (d *bigkvDeleter) Close() error {
+	return d.client.Close()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Key expressions are a human-friendly way to write binary keys. They are
+// a `+` separated list of terms, concatenated together:
+//
+//	blk: + u64be(12345) + name(eosio.token)
+//	0x0100ff + "with spaces"
+//
+// Supported terms:
+//
+//	0x<hex>, hex(<hex>)          raw bytes
+//	"<text>", '<text>', <text>   ASCII bytes (quote to use `+` or spaces)
+//	u8(n), u16be(n), u32be(n), u64be(n), u16le(n), u32le(n), u64le(n)
+//	name(<eos name>)             EOS name, as a big-endian uint64
+func parseKeyExpr(in string) ([]byte, error) {
+	terms, err := splitKeyExpr(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	for _, term := range terms {
+		val, err := parseKeyTerm(term)
+		if err != nil {
+			return nil, fmt.Errorf("term %q: %s", term, err)
+		}
+		out = append(out, val...)
+	}
+
+	return out, nil
+}
+
+func splitKeyExpr(in string) (terms []string, err error) {
+	var current strings.Builder
+	var quote byte
+	depth := 0
+
+	flush := func() {
+		term := strings.TrimSpace(current.String())
+		if term != "" {
+			terms = append(terms, term)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' && i+1 < len(in) {
+				current.WriteByte(c)
+				i++
+				c = in[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '+' && depth == 0:
+			flush()
+			continue
+		}
+		current.WriteByte(c)
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in key expression %q", in)
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parenthesis in key expression %q", in)
+	}
+
+	flush()
+	return terms, nil
+}
+
+var keyIntTerms = map[string]struct {
+	size      int
+	bigEndian bool
+}{
+	"u8":    {1, true},
+	"u16be": {2, true},
+	"u32be": {4, true},
+	"u64be": {8, true},
+	"u16le": {2, false},
+	"u32le": {4, false},
+	"u64le": {8, false},
+}
+
+func parseKeyTerm(term string) ([]byte, error) {
+	if strings.HasPrefix(term, "0x") {
+		return hex.DecodeString(term[2:])
+	}
+
+	if term[0] == '"' {
+		val, err := strconv.Unquote(term)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(val), nil
+	}
+
+	if term[0] == '\'' {
+		if len(term) < 2 || term[len(term)-1] != '\'' {
+			return nil, fmt.Errorf("invalid quoting")
+		}
+		return []byte(term[1 : len(term)-1]), nil
+	}
+
+	open := strings.IndexByte(term, '(')
+	if open == -1 || !strings.HasSuffix(term, ")") {
+		return []byte(term), nil
+	}
+
+	fn := term[:open]
+	arg := strings.TrimSpace(term[open+1 : len(term)-1])
+
+	switch fn {
+	case "hex":
+		return hex.DecodeString(arg)
+	case "name":
+		val, err := eosNameToUint64(arg)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint64(out, val)
+		return out, nil
+	}
+
+	intTerm, found := keyIntTerms[fn]
+	if !found {
+		// Not a known function, so it's just text that happens to have parenthesis
+		return []byte(term), nil
+	}
+
+	val, err := strconv.ParseUint(arg, 0, intTerm.size*8)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8)
+	if intTerm.bigEndian {
+		binary.BigEndian.PutUint64(buf, val)
+		return buf[8-intTerm.size:], nil
+	}
+	binary.LittleEndian.PutUint64(buf, val)
+	return buf[:intTerm.size], nil
+}
+
+// formatKeyExpr renders a binary key back into the key expression syntax
+// accepted by `parseKeyExpr`. It's a best effort: runs of printable
+// characters become strings, 8 bytes chunks become `name()` when they look
+// like an EOS name or `u64be()` otherwise, and the rest falls back to hex.
+func formatKeyExpr(key []byte) string {
+	if len(key) == 0 {
+		return `""`
+	}
+
+	var terms []string
+	for len(key) > 0 {
+		printable := printablePrefixLen(key)
+		if printable >= 3 || printable == len(key) || (printable > 0 && key[printable-1] == ':') {
+			terms = append(terms, strconv.Quote(string(key[:printable])))
+			key = key[printable:]
+			continue
+		}
+
+		if len(key) >= 8 && printablePrefixLen(key[:8]) != 8 {
+			val := binary.BigEndian.Uint64(key)
+			if isLikelyEOSName(val) {
+				terms = append(terms, fmt.Sprintf("name(%s)", eosNameToString(val)))
+			} else {
+				terms = append(terms, fmt.Sprintf("u64be(%d)", val))
+			}
+			key = key[8:]
+			continue
+		}
+
+		if len(key) == 4 {
+			terms = append(terms, fmt.Sprintf("u32be(%d)", binary.BigEndian.Uint32(key)))
+			break
+		}
+
+		binLen := len(key)
+		for i := 1; i < len(key); i++ {
+			if printablePrefixLen(key[i:]) >= 3 {
+				binLen = i
+				break
+			}
+		}
+
+		terms = append(terms, "0x"+hex.EncodeToString(key[:binLen]))
+		key = key[binLen:]
+	}
+
+	return strings.Join(terms, " + ")
+}
+
+func printablePrefixLen(in []byte) int {
+	for i, c := range in {
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			return i
+		}
+	}
+	return len(in)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseKeyExpr(t *testing.T) {
+	tests := []struct {
+		in          string
+		expected    string // hex
+		expectedErr bool
+	}{
+		{"0x0100ff", "0100ff", false},
+		{"hex(0100ff)", "0100ff", false},
+		{"blk:", "626c6b3a", false},
+		{`"with + plus"`, "77697468202b20706c7573", false},
+		{`'single quoted'`, "73696e676c652071756f746564", false},
+		{`"esc\"aped"`, "6573632261706564", false},
+		{"u8(255)", "ff", false},
+		{"u16be(258)", "0102", false},
+		{"u16le(258)", "0201", false},
+		{"u32be(0x01020304)", "01020304", false},
+		{"u32le(1)", "01000000", false},
+		{"u64be(12345)", "0000000000003039", false},
+		{"u64le(1)", "0100000000000000", false},
+		{"name(eosio.token)", "5530ea033482a600", false},
+		{"blk: + u64be(12345) + name(eosio)", "626c6b3a00000000000030395530ea0000000000", false},
+		{" 0x01 +0x02+ 0x03 ", "010203", false},
+		{"fn(x)", "666e287829", false},
+		{"", "", false},
+
+		{"0xzz", "", true},
+		{"u8(256)", "", true},
+		{"u16be(-1)", "", true},
+		{"name(Bad)", "", true},
+		{`"unterminated`, "", true},
+		{"u64be(1", "", true},
+		{"u64be(1))", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			actual, err := parseKeyExpr(test.in)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %x", actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			expected, _ := hex.DecodeString(test.expected)
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("expected %x, got %x", expected, actual)
+			}
+		})
+	}
+}
+
+func TestFormatKeyExpr(t *testing.T) {
+	tests := []struct {
+		in       string // hex
+		expected string
+	}{
+		{"", `""`},
+		{"626c6b3a", `"blk:"`},
+		{"626c6b3a00000000000030395530ea033482a600", `"blk:" + u64be(12345) + name(eosio.token)`},
+		{"0100ff", "0x0100ff"},
+		{"01020304", "u32be(16909060)"},
+		{"0122", "0x0122"},
+		{"ff61626364", `0xff + "abcd"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			key, _ := hex.DecodeString(test.in)
+			if actual := formatKeyExpr(key); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestKeyExprRoundTrip(t *testing.T) {
+	keys := []string{
+		"",
+		"00",
+		"ffffffffffffffff",
+		"626c6b3a00000000000030395530ea033482a600",
+		"0100ff",
+		"01020304",
+		"22",
+		"5c",
+		"7472783a22b0ff3a00",
+		"000000000000000000",
+		"616263646566676869",
+	}
+
+	for _, in := range keys {
+		t.Run(in, func(t *testing.T) {
+			key, _ := hex.DecodeString(in)
+			expr := formatKeyExpr(key)
+			back, err := parseKeyExpr(expr)
+			if err != nil {
+				t.Fatalf("parsing %s: %s", expr, err)
+			}
+			if !bytes.Equal(back, key) {
+				t.Errorf("%s: expected %x, got %x", expr, key, back)
+			}
+		})
+	}
+}
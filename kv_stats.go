@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+)
+
+func kvStats(cmd *cobra.Command, args []string) (err error) {
+	var prefix []byte
+	if len(args) > 0 {
+		prefix, err = parseKeyExpr(args[0])
+		if err != nil {
+			return fmt.Errorf("error decoding prefix %q: %s", args[0], err)
+		}
+	}
+
+	var groupPrefixes [][]byte
+	for _, expr := range viper.GetStringSlice("kv-stats-cmd-group") {
+		groupPrefix, err := parseKeyExpr(expr)
+		if err != nil {
+			return fmt.Errorf("error decoding group prefix %q: %s", expr, err)
+		}
+		groupPrefixes = append(groupPrefixes, groupPrefix)
+	}
+
+	// Longest prefixes first, so the most specific group wins
+	sort.Slice(groupPrefixes, func(i, j int) bool { return len(groupPrefixes[i]) > len(groupPrefixes[j]) })
+
+	groupBytes := viper.GetInt("kv-stats-cmd-group-bytes")
+	groupOf := func(key []byte) string {
+		if len(groupPrefixes) != 0 {
+			for _, groupPrefix := range groupPrefixes {
+				if bytes.HasPrefix(key, groupPrefix) {
+					return string(groupPrefix)
+				}
+			}
+			return ""
+		}
+
+		if len(key) > groupBytes {
+			return string(key[:groupBytes])
+		}
+		return string(key)
+	}
+
+	if err := kvConfirmScan(prefix); err != nil {
+		return err
+	}
+
+	kv, err := newKVStore()
+	if err != nil {
+		return err
+	}
+	defer closeKVStore(kv)
+
+	total := &kvGroupStats{}
+	groups := map[string]*kvGroupStats{}
+	largest := &kvLargestKeys{max: viper.GetInt("kv-stats-cmd-top")}
+
+	it := kv.Prefix(context.Background(), prefix)
+	defer it.Close()
+
+	for it.Next() {
+		item := it.Item()
+
+		group := groupOf(item.Key)
+		stats := groups[group]
+		if stats == nil {
+			stats = &kvGroupStats{}
+			groups[group] = stats
+		}
+
+		stats.add(item.Key, item.Value)
+		total.add(item.Key, item.Value)
+		largest.add(item.Key, len(item.Key)+len(item.Value))
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	var groupKeys []string
+	for group := range groups {
+		groupKeys = append(groupKeys, group)
+	}
+	sort.Strings(groupKeys)
+
+	output, err := newRecordWriter(kvStatsOutputSpec)
+	if err != nil {
+		return err
+	}
+
+	writeGroup := func(label, key string, stats *kvGroupStats) error {
+		share := 0.0
+		if totalBytes := total.keyBytes + total.valueBytes; totalBytes != 0 {
+			share = float64(stats.keyBytes+stats.valueBytes) / float64(totalBytes) * 100.0
+		}
+
+		return output.write(kvStatsRecord{
+			Group:         label,
+			Key:           key,
+			Keys:          stats.keys,
+			KeyBytes:      stats.keyBytes,
+			ValueBytes:    stats.valueBytes,
+			SharePercent:  math.Round(share*100) / 100,
+			ValueBytesP50: stats.valueSizes.percentile(0.50),
+			ValueBytesP90: stats.valueSizes.percentile(0.90),
+			ValueBytesP99: stats.valueSizes.percentile(0.99),
+			ValueBytesMax: stats.valueSizes.max,
+		})
+	}
+
+	for _, group := range groupKeys {
+		label := formatKey([]byte(group))
+		if group == "" && len(groupPrefixes) != 0 {
+			label = "(other)"
+		}
+		if err := writeGroup(label, "", groups[group]); err != nil {
+			return err
+		}
+	}
+	if err := writeGroup("(total)", "", total); err != nil {
+		return err
+	}
+
+	// Largest keys (key + value bytes) are each a group of one key
+	for _, entry := range largest.sorted() {
+		stats := &kvGroupStats{}
+		stats.addSizes(len(entry.key), entry.size-len(entry.key))
+		if err := writeGroup("(largest)", formatKey(entry.key), stats); err != nil {
+			return err
+		}
+	}
+
+	return output.close()
+}
+
+// kvStatsRecord is one group of `doh kv stats`, sizes in bytes. The
+// `(largest)` group records are of a single key.
+type kvStatsRecord struct {
+	Group         string  `json:"group"`
+	Key           string  `json:"key,omitempty"`
+	Keys          uint64  `json:"keys"`
+	KeyBytes      uint64  `json:"key_bytes"`
+	ValueBytes    uint64  `json:"value_bytes"`
+	SharePercent  float64 `json:"share_percent"`
+	ValueBytesP50 uint64  `json:"value_bytes_p50"`
+	ValueBytesP90 uint64  `json:"value_bytes_p90"`
+	ValueBytesP99 uint64  `json:"value_bytes_p99"`
+	ValueBytesMax uint64  `json:"value_bytes_max"`
+}
+
+func humanizeBytesColumn(value gjson.Result) string {
+	return humanize.Bytes(value.Uint())
+}
+
+var kvStatsOutputSpec = outputSpec{
+	defaultFormat: "table",
+	columns: []outputColumn{
+		{header: "group", path: "group"},
+		{header: "key", path: "key", whole: true},
+		{header: "keys", path: "keys", format: func(value gjson.Result) string { return humanize.Comma(value.Int()) }},
+		{header: "key bytes", path: "key_bytes", format: humanizeBytesColumn},
+		{header: "value bytes", path: "value_bytes", format: humanizeBytesColumn},
+		{header: "% bytes", path: "share_percent", format: func(value gjson.Result) string { return fmt.Sprintf("%.2f%%", value.Float()) }},
+		{header: "p50", path: "value_bytes_p50", format: humanizeBytesColumn},
+		{header: "p90", path: "value_bytes_p90", format: humanizeBytesColumn},
+		{header: "p99", path: "value_bytes_p99", format: humanizeBytesColumn},
+		{header: "max", path: "value_bytes_max", format: humanizeBytesColumn},
+	},
+}
+
+type kvGroupStats struct {
+	keys       uint64
+	keyBytes   uint64
+	valueBytes uint64
+	valueSizes sizeHistogram
+}
+
+func (s *kvGroupStats) add(key, value []byte) {
+	s.addSizes(len(key), len(value))
+}
+
+func (s *kvGroupStats) addSizes(keySize, valueSize int) {
+	s.keys++
+	s.keyBytes += uint64(keySize)
+	s.valueBytes += uint64(valueSize)
+	s.valueSizes.add(uint64(valueSize))
+}
+
+// sizeHistogram is a constant memory log-linear histogram: exact up to 16,
+// then 8 buckets per power of two, so percentiles are within 12.5%.
+type sizeHistogram struct {
+	buckets [16 + 60*8]uint64
+	count   uint64
+	max     uint64
+}
+
+func (h *sizeHistogram) add(size uint64) {
+	h.buckets[sizeBucket(size)]++
+	h.count++
+	if size > h.max {
+		h.max = size
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the `p`th
+// percentile, `p` being between 0 and 1.
+func (h *sizeHistogram) percentile(p float64) uint64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(p * float64(h.count)))
+	if target == 0 {
+		target = 1
+	}
+
+	var cumulative uint64
+	for idx, count := range h.buckets {
+		cumulative += count
+		if cumulative >= target {
+			if upper := sizeBucketUpperBound(idx); upper < h.max {
+				return upper
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+func sizeBucket(size uint64) int {
+	if size < 16 {
+		return int(size)
+	}
+
+	octave := bits.Len64(size) - 1
+	sub := (size >> uint(octave-3)) & 7
+	return 16 + (octave-4)*8 + int(sub)
+}
+
+func sizeBucketUpperBound(idx int) uint64 {
+	if idx < 16 {
+		return uint64(idx)
+	}
+
+	octave := uint((idx-16)/8 + 4)
+	sub := uint64((idx - 16) % 8)
+	lower := (8 + sub) << (octave - 3)
+	return lower + (1 << (octave - 3)) - 1
+}
+
+type kvSizedKey struct {
+	key  []byte
+	size int
+}
+
+// kvLargestKeys is a min-heap keeping the `max` largest keys seen
+type kvLargestKeys struct {
+	max     int
+	entries []kvSizedKey
+}
+
+func (l *kvLargestKeys) add(key []byte, size int) {
+	if l.max <= 0 {
+		return
+	}
+
+	if len(l.entries) < l.max {
+		heap.Push(l, kvSizedKey{key: append([]byte{}, key...), size: size})
+		return
+	}
+
+	if size > l.entries[0].size {
+		l.entries[0] = kvSizedKey{key: append([]byte{}, key...), size: size}
+		heap.Fix(l, 0)
+	}
+}
+
+func (l *kvLargestKeys) sorted() []kvSizedKey {
+	out := append([]kvSizedKey{}, l.entries...)
+	sort.Slice(out, func(i, j int) bool { return out[i].size > out[j].size })
+	return out
+}
+
+func (l *kvLargestKeys) Len() int           { return len(l.entries) }
+func (l *kvLargestKeys) Less(i, j int) bool { return l.entries[i].size < l.entries[j].size }
+func (l *kvLargestKeys) Swap(i, j int)      { l.entries[i], l.entries[j] = l.entries[j], l.entries[i] }
+func (l *kvLargestKeys) Push(x interface{}) { l.entries = append(l.entries, x.(kvSizedKey)) }
+func (l *kvLargestKeys) Pop() interface{} {
+	last := l.entries[len(l.entries)-1]
+	l.entries = l.entries[:len(l.entries)-1]
+	return last
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// kvRecord is one line of the `doh kv export` / `doh kv import` JSONL
+// format. Both fields are key expressions, so `0x` prefixed hex as
+// produced by `export`, or anything `parseKeyExpr` understands.
+type kvRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func kvPut(cmd *cobra.Command, args []string) (err error) {
+	key, err := parseKeyExpr(args[0])
+	if err != nil {
+		return fmt.Errorf("error decoding key %q: %s", args[0], err)
+	}
+
+	value, err := parseKeyExpr(args[1])
+	if err != nil {
+		return fmt.Errorf("error decoding value %q: %s", args[1], err)
+	}
+
+	if err := kvCheckWriteAllowed("put a key"); err != nil {
+		return err
+	}
+
+	kv, err := newKVStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := kv.Put(ctx, key, value); err != nil {
+		closeKVStore(kv)
+		return fmt.Errorf("put: %s", err)
+	}
+
+	if err := kv.FlushPuts(ctx); err != nil {
+		closeKVStore(kv)
+		return fmt.Errorf("flush puts: %s", err)
+	}
+
+	return closeKVStore(kv)
+}
+
+func kvDelete(cmd *cobra.Command, args []string) (err error) {
+	start, err := parseKeyExpr(args[0])
+	if err != nil {
+		return fmt.Errorf("error decoding key %q: %s", args[0], err)
+	}
+
+	end := append(append([]byte{}, start...), 0x00)
+	if viper.GetBool("kv-delete-cmd-prefix") {
+		end = prefixNext(start)
+	}
+
+	dsn, err := kvStoreDSN()
+	if err != nil {
+		return err
+	}
+
+	dryRun := viper.GetBool("kv-delete-cmd-dry-run")
+	if !dryRun {
+		if err := confirmProd(kvTarget(dsn), "delete keys"); err != nil {
+			return err
+		}
+	}
+
+	deleter, err := newKVDeleter(dsn)
+	if err != nil {
+		return err
+	}
+	defer deleter.Close()
+
+	count, err := deleter.DeleteRange(context.Background(), start, end, dryRun)
+	if err != nil {
+		return fmt.Errorf("delete (after %d keys): %s", count, err)
+	}
+
+	if dryRun {
+		fmt.Printf("Would delete %d keys\n", count)
+		return nil
+	}
+
+	fmt.Printf("Deleted %d keys\n", count)
+	return nil
+}
+
+func kvImport(cmd *cobra.Command, args []string) (err error) {
+	var reader io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	if err := kvCheckWriteAllowed("import keys"); err != nil {
+		return err
+	}
+
+	kv, err := newKVStore()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeKVStore(kv); err == nil {
+			err = closeErr
+		}
+	}()
+
+	ctx := context.Background()
+	batchSize := viper.GetInt("kv-import-cmd-batch-size")
+	decoder := json.NewDecoder(reader)
+
+	count := 0
+	for {
+		var record kvRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("decoding record #%d: %s", count+1, err)
+		}
+
+		key, err := parseKeyExpr(record.Key)
+		if err != nil {
+			return fmt.Errorf("record #%d: error decoding key %q: %s", count+1, record.Key, err)
+		}
+
+		value, err := parseKeyExpr(record.Value)
+		if err != nil {
+			return fmt.Errorf("record #%d: error decoding value: %s", count+1, err)
+		}
+
+		if err := kv.Put(ctx, key, value); err != nil {
+			return fmt.Errorf("record #%d: put: %s", count+1, err)
+		}
+
+		count++
+		if batchSize > 0 && count%batchSize == 0 {
+			if err := kv.FlushPuts(ctx); err != nil {
+				return fmt.Errorf("flush puts: %s", err)
+			}
+		}
+	}
+
+	if err := kv.FlushPuts(ctx); err != nil {
+		return fmt.Errorf("flush puts: %s", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d keys\n", count)
+	return nil
+}
+
+func kvExport(cmd *cobra.Command, args []string) (err error) {
+	prefix, err := parseKeyExpr(args[0])
+	if err != nil {
+		return fmt.Errorf("error decoding prefix %q: %s", args[0], err)
+	}
+
+	if err := kvConfirmScan(prefix); err != nil {
+		return err
+	}
+
+	kv, err := newKVStore()
+	if err != nil {
+		return err
+	}
+	defer closeKVStore(kv)
+
+	encoder := json.NewEncoder(os.Stdout)
+
+	it := kv.Prefix(context.Background(), prefix)
+	defer it.Close()
+
+	for it.Next() {
+		item := it.Item()
+		record := kvRecord{
+			Key:   formatExportKey(item.Key),
+			Value: "0x" + hex.EncodeToString(item.Value),
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
+
+// formatExportKey is like `formatKey`, but always returns a valid key
+// expression, since plain hex would be read back as ASCII.
+func formatExportKey(key []byte) string {
+	if viper.GetString("kv-global-key-format") == "expr" {
+		return formatKeyExpr(key)
+	}
+	return "0x" + hex.EncodeToString(key)
+}
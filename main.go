@@ -21,10 +21,9 @@ import (
 	"github.com/abourget/viperbind"
 	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
 	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	pbdeth "github.com/dfuse-io/doh/pb/dfuse/codecs/deth"
 	"github.com/dfuse-io/jsonpb"
-	"github.com/dustin/go-humanize"
 	"github.com/golang/protobuf/proto"
-	zstd2 "github.com/klauspost/compress/zstd"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/tcnksm/go-gitconfig"
@@ -39,7 +38,15 @@ var fluxShardCmd = &cobra.Command{Use: "flux [path]", Short: "Display contents o
 var btCmd = &cobra.Command{Use: "bt", Short: "big table related things"}
 var btLsCmd = &cobra.Command{Use: "ls", Short: "list tables form big table", RunE: btLs}
 var btReadCmd = &cobra.Command{Use: "read [table]", Short: "read rows from big table", RunE: btRead, Args: cobra.ExactArgs(1)}
-var btTestCompressionCmd = &cobra.Command{Use: "test-compression [table]", Short: "test compression", RunE: btTestCompression, Args: cobra.ExactArgs(1)}
+var btWriteCmd = &cobra.Command{Use: "write [table] [file.jsonl]", Short: "apply JSONL row mutations (from file or stdin) to big table", Long: btWriteHelp, RunE: btWrite, Args: cobra.RangeArgs(1, 2)}
+var btDeleteCmd = &cobra.Command{Use: "delete [table]", Short: "delete rows selected by key ranges from big table, or only their cells matching --family, --column and --ts-start/--ts-end", RunE: btDelete, Args: cobra.ExactArgs(1)}
+var btDescribeCmd = &cobra.Command{Use: "describe [table]", Short: "show column families, gc policies and tablets of a big table table", RunE: btDescribe, Args: cobra.ExactArgs(1)}
+var btCreateCmd = &cobra.Command{Use: "create [name-prefix]", Short: "create the tables of a schema preset, named [name-prefix]-[table]", RunE: btCreate, Args: cobra.ExactArgs(1)}
+var btExportCmd = &cobra.Command{Use: "export [table]", Short: "export rows, with all their cell versions, to a JSONL (optionally zstd) file", Long: btExportHelp, RunE: btExport, Args: cobra.ExactArgs(1)}
+var btImportCmd = &cobra.Command{Use: "import [table] [file]", Short: "replay a `bt export` file (from file or stdin) into big table", RunE: btImport, Args: cobra.RangeArgs(1, 2)}
+var btCountCmd = &cobra.Command{Use: "count [table]", Short: "count rows, scanning shards of the table in parallel and skipping values", RunE: btCount, Args: cobra.ExactArgs(1)}
+var btDropCmd = &cobra.Command{Use: "drop [table]...", Short: "drop big table tables", RunE: btDrop, Args: cobra.MinimumNArgs(1)}
+var btTestCompressionCmd = &cobra.Command{Use: "test-compression [table]", Short: "compare compression codecs and levels on the cells of a table, per column family", RunE: btTestCompression, Args: cobra.ExactArgs(1)}
 var deployCmd = &cobra.Command{Use: "deploy [component] [tag] [namespace]", Short: "deploy the following `component` using `tag` on given `namespace`", RunE: deploy, Args: cobra.ExactArgs(3)}
 
 var completionCmd = &cobra.Command{Use: "shell-completion", Short: "Generate shell completions"}
@@ -73,13 +80,13 @@ var protoMappings = map[pbbstream.Protocol]map[string]proto.Message{
 		"meta_blockheader":    &pbdeos.BlockHeader{},
 	},
 
-	// pbbstream.Protocol_ETH: map[string]proto.Message{
-	// 	"block_headerProto":  &pbdeth.BlockHeader{},
-	// 	"block_trxRefsProto": &pbdeth.TransactionRefs{},
-	// 	"block_uncles":       &pbdeth.UnclesHeaders{},
-	// 	"trx_proto":          &pbdeth.TransactionTrace{},
-	// 	"trx_blkRefProto":    &pbdeth.BlockRef{},
-	// },
+	pbbstream.Protocol_ETH: map[string]proto.Message{
+		"block_headerProto":  &pbdeth.BlockHeader{},
+		"block_trxRefsProto": &pbdeth.TransactionRefs{},
+		"block_uncles":       &pbdeth.UnclesHeaders{},
+		"trx_proto":          &pbdeth.TransactionTrace{},
+		"trx_blkRefProto":    &pbdeth.BlockRef{},
+	},
 }
 
 func main() {
@@ -95,6 +102,14 @@ func main() {
 
 	btCmd.AddCommand(btLsCmd)
 	btCmd.AddCommand(btReadCmd)
+	btCmd.AddCommand(btWriteCmd)
+	btCmd.AddCommand(btDeleteCmd)
+	btCmd.AddCommand(btDescribeCmd)
+	btCmd.AddCommand(btCreateCmd)
+	btCmd.AddCommand(btDropCmd)
+	btCmd.AddCommand(btExportCmd)
+	btCmd.AddCommand(btCountCmd)
+	btCmd.AddCommand(btImportCmd)
 	btCmd.AddCommand(btTestCompressionCmd)
 
 	completionCmd.AddCommand(completionZshCompletionCmd)
@@ -103,28 +118,82 @@ func main() {
 	pbCmd.Flags().StringP("type", "t", "", "A (partial) type. Will crawl the .proto files in -I and do fnmatch")
 	pbCmd.Flags().StringP("input", "i", "-", "Input file. '-' for stdin (default)")
 	pbCmd.Flags().IntP("depth", "d", 1, "Depth of decoding. 0 = top-level block, 1 = kind-specific blocks, 2 = future!")
-	btCmd.PersistentFlags().String("db", "dfuseio-global:dfuse-saas", "bigtable project and instance")
+	addTreeFlag(pbCmd)
+	addETHABIFlags(pbCmd)
+	btCmd.PersistentFlags().String("db", "", "bigtable project:instance, or a profile name, defaults to the --profile one")
 
-	btReadCmd.Flags().String("prefix", "", "bigtable prefix key")
-	btReadCmd.Flags().String("ts-start", "", "Filter rows on timestamp, in number of milliseconds since EPOCH")
-	btReadCmd.Flags().String("ts-end", "", "Filter rows on timestamp, in number of milliseconds since EPOCH")
-	btReadCmd.Flags().Bool("all-cells", false, "List all cell values, instead of limiting to one timetsamp per cell, which is the default.")
+	addBTRowSelectionFlags(btReadCmd)
 	btReadCmd.Flags().StringP("protocol", "p", "", "block protocol value to assume of the data")
 	btReadCmd.Flags().IntP("limit", "l", 100, "limit the number of rows returned")
 	btReadCmd.Flags().IntP("depth", "d", 1, "Depth of decoding. 0 = top-level block, 1 = kind-specific blocks, 2 = future!")
+	addBTParallelFlags(btReadCmd, 0)
+	btReadCmd.Flags().Bool("history", false, "read all cell versions, showing the oldest value of each column followed by the changes of each newer version")
+	btReadCmd.Flags().Bool("decompress", true, "decompress zstd and gzip cell values, marking the row with _compressed")
+	btReadCmd.Flags().StringSlice("zstd-dict", nil, "zstd dictionary files for dictionary-compressed cells (repeatable)")
+	addTreeFlag(btReadCmd)
+	addETHABIFlags(btReadCmd)
+
+	btWriteCmd.Flags().StringP("protocol", "p", "", "block protocol value to assume when encoding JSON objects to protobuf")
+	btWriteCmd.Flags().Int("batch-size", 500, "number of rows applied at once")
+
+	addBTRowSelectionFlags(btDeleteCmd)
+	btDeleteCmd.Flags().Bool("dry-run", false, "only count the rows that would be deleted")
+
+	btDescribeCmd.Flags().Bool("show-splits", false, "list the tablets boundary keys")
+
+	btCreateCmd.Flags().String("schema", "eos-v1", "schema preset, one of: eos-v1, eth-v1")
+	btCreateCmd.Flags().StringSlice("tables", nil, "only create these tables of the schema (ex: trxs,timeline)")
 
-	btTestCompressionCmd.Flags().String("prefix", "", "bigtable prefix key")
+	addBTRowSelectionFlags(btExportCmd)
+	// Exports are snapshots, keep every cell version by default
+	btExportCmd.Flags().Lookup("all-cells").DefValue = "true"
+	btExportCmd.Flags().Set("all-cells", "true")
+	btExportCmd.Flags().StringP("file", "f", "-", "export file, zstd compressed when ending with .zst, '-' for stdout")
+	btExportCmd.Flags().Int("limit", 0, "Limit number of rows exported")
+	addBTParallelFlags(btExportCmd, 0)
+
+	addBTRowSelectionFlags(btCountCmd)
+	addBTParallelFlags(btCountCmd, 8)
+
+	btImportCmd.Flags().Int("batch-size", 500, "number of rows applied at once")
+	btImportCmd.Flags().Bool("create", false, "create the table and its column families, from the export header, when missing")
+
+	addBTRowSelectionFlags(btTestCompressionCmd)
+	addCompressionBenchFlags(btTestCompressionCmd)
+	btTestCompressionCmd.Flags().String("group-by", "family", "group cells by 'family' or 'column'")
 	btTestCompressionCmd.Flags().IntP("limit", "l", 100, "limit the number of rows returned")
 
 	//dbinCmd.Flags().BoolP("list", "l", false, "Return as list instead of as JSONL")
 	//decodeCmd.Flags().Bool("enable-upload", false, "Upload merged indexes to the --indexes-store")
+	fluxShardCmd.Flags().String("ts-start", "", "only keep write requests at or after this block time: "+timeExprHelp)
+	fluxShardCmd.Flags().String("ts-end", "", "only keep write requests before this block time: "+timeExprHelp)
+	fluxShardCmd.Flags().String("db", "", "bigtable project:instance or profile, to resolve times to blocks (defaults to the --profile one)")
+	fluxShardCmd.Flags().String("timeline-table", "", "timeline table used to resolve times to blocks (ex: eos-mainnet-v1-timeline), block:NUM bounds don't need it")
+
 	deployCmd.Flags().String("operator-path", "", "Absolute path to dfuse-operator repository")
 
 	if err := rootCmd.Execute(); err != nil {
+		if exitErr, ok := err.(*exitError); ok {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
 
+// Any other error exits with code 1
+const exitCodeNotFound = 3
+
+// exitError is returned by commands needing a specific process exit code,
+// scripts can rely on those to tell apart different failure modes.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
 func pb(cmd *cobra.Command, args []string) (err error) {
 	searchType := viper.GetString("pb-cmd-type")
 
@@ -162,6 +231,32 @@ func pb(cmd *cobra.Command, args []string) (err error) {
 	typ := proto.MessageType(matchingType)
 	el = reflect.New(typ.Elem()).Interface().(proto.Message)
 
+	mode, err := treeMode("pb-cmd")
+	if err != nil {
+		return err
+	}
+	if err := loadETHABIs("pb-cmd"); err != nil {
+		return err
+	}
+	if mode != "" {
+		if err := proto.Unmarshal(buf.Bytes(), el); err != nil {
+			return fmt.Errorf("proto unmarshal: %s", err)
+		}
+
+		protocol, err := traceTreesProtocol(el)
+		if err != nil {
+			return err
+		}
+		output, err := newTreeRecordWriter(mode, protocol)
+		if err != nil {
+			return err
+		}
+		if err := writeTraceTrees(mode, output, el); err != nil {
+			return err
+		}
+		return closeTreeRecordWriter(output)
+	}
+
 	depth := viper.GetInt("pb-cmd-depth")
 	pbmarsh := jsonpb.Marshaler{
 		EnumsAsInts:  false,
@@ -202,158 +297,18 @@ func btLs(cmd *cobra.Command, args []string) (err error) {
 }
 
 var latestCellOnly = bigtable.LatestNFilter(1)
-var latestCellFilter = bigtable.RowFilter(latestCellOnly)
 
-func btTestCompression(cmd *cobra.Command, args []string) (err error) {
+func btRead(cmd *cobra.Command, args []string) (err error) {
 	project, instance, err := splitDb()
 	if err != nil {
 		return err
 	}
 
-	protocol := pbbstream.Protocol_EOS
-
-	client, err := newBigTableClient(project, instance)
-	if err != nil {
-		return err
-	}
-
-	var innerError error
-
-	opts := []bigtable.ReadOption{}
-	opts = append(opts, latestCellFilter)
-
-	tsStart := viper.GetString("bt-read-cmd-ts-start")
-	tsEnd := viper.GetString("bt-read-cmd-ts-end")
-	if tsStart != "" || tsEnd != "" {
-		start, err := msToBTTimestamp(tsStart)
-		if err != nil {
-			return err
-		}
-		end, err := msToBTTimestamp(tsEnd)
-		if err != nil {
-			return err
-		}
-		opts = append(opts, bigtable.RowFilter(bigtable.TimestampRangeFilterMicros(start, end)))
-	}
-
-	limit := viper.GetInt("bt-test-compression-cmd-limit")
-	if limit != 0 {
-		opts = append(opts, bigtable.LimitRows(int64(limit)))
-	}
-
-	prefix := viper.GetString("bt-test-compression-cmd-prefix")
-
-	var rowset bigtable.RowSet
-	if prefix != "" {
-		rowset = bigtable.PrefixRange(prefix)
-	} else {
-		rowset = bigtable.InfiniteRange("")
-	}
-
-	var rowCount, valCount, itemCount int
-	var uncompressedBytes int
-	var compressedBytes int
-	var compressionTime time.Duration
-	var decompressionTime time.Duration
-	var unmarshalingTime time.Duration
-
-	dec, _ := zstd2.NewReader(nil)
-	enc, _ := zstd2.NewWriter(nil) // , zstd2.WithEncoderLevel(zstd2.SpeedFastest))
-
-	t0 := time.Now()
-	err = client.Open(args[0]).ReadRows(context.Background(), rowset, func(row bigtable.Row) bool {
-		rowCount++
-		for _, v := range row {
-			itemCount++
-			for _, item := range v {
-				valCount++
-				// TODO: compress, decompress the item.Value
-				// inc compressionTime, decompressionTime
-				uncompressedBytes += len(item.Value)
-
-				var uncompressedAgain []byte
-				if len(item.Value) > 128 {
-					t2 := time.Now()
-					out := enc.EncodeAll(item.Value, nil)
-					compressionTime += time.Since(t2)
-
-					compressedBytes += len(out)
-
-					t3 := time.Now()
-					uncompressedAgain, err = dec.DecodeAll(out, nil)
-					decompressionTime += time.Since(t3)
-				} else {
-					compressedBytes += len(item.Value)
-					uncompressedAgain = item.Value
-				}
-
-				if err != nil {
-					panic("failed decoding whatever")
-				}
-				if len(uncompressedAgain) != len(item.Value) {
-					panic("compression/decompression didn't yield same number of bytes")
-				}
-
-				key := strings.Replace(item.Column, "-", "_", -1)
-				key = strings.Replace(key, ":", "_", -1)
-				protoMessage := getProtoMap(protocol, key)
-				if protoMessage != nil {
-					t1 := time.Now()
-					if err := proto.Unmarshal(item.Value, protoMessage); err != nil {
-						innerError = fmt.Errorf("proto unmarshal: %s", err)
-						return false
-					}
-					unmarshalingTime += time.Since(t1)
-				}
-
-				// on error, set innerError, return `false`
-			}
-		}
-
-		return true
-	}, opts...)
-	if err != nil {
-		return err
-	}
-	if innerError != nil {
-		return innerError
-	}
-
-	totalDuration := time.Since(t0)
-
-	netApprox := totalDuration - compressionTime - decompressionTime - unmarshalingTime
-	fmt.Printf("Processed %s rows prefixed %q from table %q, compressing, decompressing, and unmarshaling into protobuf structs\n", humanize.Comma(int64(limit)), prefix, args[0])
-	fmt.Println("")
-	fmt.Println("Total time:", totalDuration)
-	fmt.Println("Compression time:", compressionTime)
-	fmt.Println("Decompression time:", decompressionTime)
-	fmt.Println("Unmarshaling time:", unmarshalingTime)
-	fmt.Println("Network time approximation:", netApprox)
-	fmt.Println("")
-
-	fmt.Printf("Compression time vs network approx ratio: %.2f%%\n", float64(compressionTime)/float64(netApprox)*100.0)
-	fmt.Printf("Decompression time vs network approx ratio: %.2f%%\n", float64(decompressionTime)/float64(netApprox)*100.0)
-	fmt.Printf("Decompression vs unmarshal ratio: %.2f%%\n", float64(decompressionTime)/float64(unmarshalingTime)*100.0)
-
-	fmt.Println("")
-
-	fmt.Printf("Counts:\n   Rows: %s\n   Items: %s\n   Values: %s  (avg size: %s)\n", humanize.Comma(int64(rowCount)), humanize.Comma(int64(itemCount)), humanize.Comma(int64(valCount)), humanize.Comma(int64(uncompressedBytes/valCount)))
-	fmt.Println("")
-
-	fmt.Println("Uncompressed bytes:", humanize.Bytes(uint64(uncompressedBytes)))
-	fmt.Println("Compressed bytes:", humanize.Bytes(uint64(compressedBytes)))
-	fmt.Printf("Compression ratio: %.2f%%\n", float64(compressedBytes)/float64(uncompressedBytes)*100.0)
-
-	return nil
-}
-
-func btRead(cmd *cobra.Command, args []string) (err error) {
-	project, instance, err := splitDb()
-	if err != nil {
+	if err := btConfirmScan(project, instance, "bt-read-cmd", args[0]); err != nil {
 		return err
 	}
 
-	flagProtocol := viper.GetString("bt-read-cmd-protocol")
+	flagProtocol := protocolOrDefault(viper.GetString("bt-read-cmd-protocol"))
 	protocol := pbbstream.Protocol(pbbstream.Protocol_value[flagProtocol])
 	if protocol == pbbstream.Protocol_UNKNOWN {
 		return fmt.Errorf("invalid block --protocol value: %q", flagProtocol)
@@ -372,72 +327,137 @@ func btRead(cmd *cobra.Command, args []string) (err error) {
 
 	var innerError error
 
-	opts := []bigtable.ReadOption{}
+	keyLayouts, err := btKeyLayoutsForTable("bt-read-cmd", args[0])
+	if err != nil {
+		return err
+	}
+
+	depth := viper.GetInt("bt-read-cmd-depth")
+	history := viper.GetBool("bt-read-cmd-history")
+	allVersions := history || viper.GetBool("bt-read-cmd-all-cells")
 
-	if !viper.GetBool("bt-read-cmd-all-cells") {
-		opts = append(opts, latestCellFilter)
+	tree, err := treeMode("bt-read-cmd")
+	if err != nil {
+		return err
+	}
+	if tree != "" && allVersions {
+		return fmt.Errorf("--tree renders the latest cell versions only, it can't be used with --all-cells or --history")
+	}
+	if err := loadETHABIs("bt-read-cmd"); err != nil {
+		return err
 	}
 
-	tsStart := viper.GetString("bt-read-cmd-ts-start")
-	tsEnd := viper.GetString("bt-read-cmd-ts-end")
-	if tsStart != "" || tsEnd != "" {
-		start, err := msToBTTimestamp(tsStart)
+	var decompressor *valueDecompressor
+	if viper.GetBool("bt-read-cmd-decompress") {
+		decompressor, err = newValueDecompressor(viper.GetStringSlice("bt-read-cmd-zstd-dict"))
 		if err != nil {
 			return err
 		}
-		end, err := msToBTTimestamp(tsEnd)
-		if err != nil {
-			return err
-		}
-		opts = append(opts, bigtable.RowFilter(bigtable.TimestampRangeFilterMicros(start, end)))
 	}
 
-	if limit := viper.GetInt("bt-read-cmd-limit"); limit != 0 {
-		opts = append(opts, bigtable.LimitRows(int64(limit)))
-	}
-
-	prefix := viper.GetString("bt-read-cmd-prefix")
-	depth := viper.GetInt("bt-read-cmd-depth")
-
-	var rowset bigtable.RowSet
-	if prefix != "" {
-		rowset = bigtable.PrefixRange(prefix)
-	} else {
-		rowset = bigtable.InfiniteRange("")
+	output, err := newRecordWriter(btRowsOutputSpec)
+	if err != nil {
+		return err
 	}
 
-	err = client.Open(args[0]).ReadRows(context.Background(), rowset, func(row bigtable.Row) bool {
+	parallel := viper.GetInt("bt-read-cmd-parallel")
+	ordered := !viper.GetBool("bt-read-cmd-unordered")
+	err = btReadRows(context.Background(), client.Open(args[0]), "bt-read-cmd", args[0], parallel, ordered, func(row bigtable.Row) bool {
 		formatedRow := map[string]interface{}{
 			"_key": row.Key(),
 		}
+		if parsedKey := parseBTKey(keyLayouts, row.Key()); parsedKey != nil {
+			formatedRow["_key_parsed"] = parsedKey
+		}
 
+		var latestTimestamp bigtable.Timestamp
+		var traces []proto.Message
 		for _, v := range row {
 			for _, item := range v {
 				key := strings.Replace(item.Column, "-", "_", -1)
-				formatedRow["_ts"] = item.Timestamp.Time().UTC().Format(time.RFC3339Nano)
 				key = strings.Replace(key, ":", "_", -1)
-				protoMessage := getProtoMap(protocol, key)
 
+				value := item.Value
+				if decompressor != nil {
+					// Values that only look compressed are shown as is
+					decompressed, codec, err := decompressor.decompress(value)
+					if err != nil {
+						formatedRow["_compressed_error"] = fmt.Sprintf("%s: %s", item.Column, err)
+					} else if codec != "" {
+						value = decompressed
+						formatedRow["_compressed"] = codec
+					}
+				}
+
+				var decoded interface{}
+				protoMessage := getProtoMap(protocol, key)
 				if (protoMessage != nil) && (depth != 0) {
-					formatedRow[key], err = decodePayload(pbmarsh, protoMessage, item.Value)
+					decoded, err = decodePayload(pbmarsh, protoMessage, value)
 					if err != nil {
 						innerError = err
 						return false
 					}
+
+					if tree != "" {
+						record, err := traceTreeRecord(protoMessage)
+						if err != nil {
+							innerError = fmt.Errorf("row %q: %s", row.Key(), err)
+							return false
+						}
+						if record != nil {
+							decoded = record
+							traces = append(traces, protoMessage)
+						}
+					}
 				} else {
-					formatedRow[key] = string(item.Value)
+					decoded = string(value)
+				}
+
+				if !allVersions {
+					formatedRow["_ts"] = formatBTTimestamp(item.Timestamp)
+					formatedRow[key] = decoded
+					continue
+				}
+
+				if item.Timestamp > latestTimestamp {
+					latestTimestamp = item.Timestamp
+					formatedRow["_ts"] = formatBTTimestamp(item.Timestamp)
 				}
+				versions, _ := formatedRow[key].([]btCellVersion)
+				formatedRow[key] = append(versions, btCellVersion{Timestamp: formatBTTimestamp(item.Timestamp), Value: decoded})
 			}
 		}
 
-		cnt, err := json.Marshal(formatedRow)
-		if err != nil {
+		if history {
+			for key, value := range formatedRow {
+				versions, ok := value.([]btCellVersion)
+				if !ok {
+					continue
+				}
+				formatedRow[key], err = btCellHistory(versions)
+				if err != nil {
+					innerError = fmt.Errorf("row %q: %s", row.Key(), err)
+					return false
+				}
+			}
+		}
+
+		// Text trees replace the rows, which are skipped when without traces
+		if tree == "text" {
+			for _, trace := range traces {
+				if innerError = writeTraceTrees(tree, nil, trace); innerError != nil {
+					return false
+				}
+			}
+			return true
+		}
+
+		if err := output.write(formatedRow); err != nil {
 			innerError = err
 			return false
 		}
-		fmt.Println(string(cnt))
 		return true
-	}, opts...)
+	})
 
 	if err != nil {
 		return err
@@ -447,7 +467,17 @@ func btRead(cmd *cobra.Command, args []string) (err error) {
 		return innerError
 	}
 
-	return nil
+	return output.close()
+}
+
+// Big table rows have a column per cell, which vary by table
+var btRowsOutputSpec = outputSpec{
+	defaultFormat: "jsonl",
+	columns: []outputColumn{
+		{header: "_key", path: "_key"},
+		{header: "_ts", path: "_ts"},
+	},
+	otherFields: true,
 }
 
 func inputFile(args []string) (io.ReadCloser, error) {
@@ -464,13 +494,10 @@ func inputFile(args []string) (io.ReadCloser, error) {
 }
 
 func splitDb() (project, instance string, err error) {
-	db := viper.GetString("bt-global-db")
-	if db == "prod" {
-		return "dfuseio-global", "dfuse-saas", nil
-	} else if db == "dev" {
-		return "dev", "dev", nil
-	}
+	return resolveDb(viper.GetString("bt-global-db"))
+}
 
+func parseDb(db string) (project, instance string, err error) {
 	parts := strings.Split(db, ":")
 	if len(parts) != 2 {
 		return "", "", fmt.Errorf("invalid --db field")
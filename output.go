@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v2"
+)
+
+const outputFormatsHelp = "json, jsonl, pretty, yaml, csv, table"
+
+// Table cells longer than this are cut, other formats output values whole
+const tableCellMaxWidth = 80
+
+// outputColumn is a `csv` / `table` column, `path` being a gjson path
+// (https://github.com/tidwall/gjson#path-syntax) in each record's JSON.
+// `format`, when set, renders the value in `table` output, for humans.
+type outputColumn struct {
+	header  string
+	path    string
+	format  func(gjson.Result) string
+	missing string // value of records without the field, empty by default
+	whole   bool   // never cut in `table` output, for data like keys and values
+}
+
+// outputSpec describes a command's records: its default format, and the
+// columns of the `csv` and `table` formats.
+type outputSpec struct {
+	defaultFormat string
+	columns       []outputColumn
+
+	// otherFields appends a column for each other top level field of the
+	// first record, for records without a fixed shape (like big table rows).
+	// Rows are streamed, so fields that only later records have are left out
+	// of `csv` and `table`, the JSON formats keep them.
+	otherFields bool
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("output", "", "output format, one of: "+outputFormatsHelp+" (defaults depend on the command)")
+}
+
+// recordWriter is the common output layer: commands hand it records (any
+// value marshaling to a JSON object) and it renders them in the `--output`
+// format.
+type recordWriter struct {
+	format string
+	spec   outputSpec
+	out    io.Writer
+
+	columns []outputColumn
+	csv     *csv.Writer
+	table   *tabwriter.Writer
+	count   int
+}
+
+func newRecordWriter(spec outputSpec) (*recordWriter, error) {
+	format := viper.GetString("global-output")
+	if format == "" {
+		format = spec.defaultFormat
+	}
+
+	w := &recordWriter{format: format, spec: spec, out: os.Stdout, columns: spec.columns}
+	switch format {
+	case "json", "jsonl", "pretty", "yaml":
+	case "csv":
+		w.csv = csv.NewWriter(w.out)
+	case "table":
+		w.table = tabwriter.NewWriter(w.out, 0, 4, 2, ' ', 0)
+	default:
+		return nil, fmt.Errorf("invalid --output %q, expected one of: %s", format, outputFormatsHelp)
+	}
+	return w, nil
+}
+
+func (w *recordWriter) write(record interface{}) (err error) {
+	data, ok := record.(json.RawMessage)
+	if !ok {
+		if data, err = json.Marshal(record); err != nil {
+			return fmt.Errorf("encoding record: %s", err)
+		}
+	}
+	defer func() { w.count++ }()
+
+	switch w.format {
+	case "jsonl":
+		_, err = fmt.Fprintln(w.out, string(data))
+		return err
+
+	case "json":
+		prefix := ",\n  "
+		if w.count == 0 {
+			prefix = "[\n  "
+		}
+		_, err = fmt.Fprint(w.out, prefix+string(data))
+		return err
+
+	case "pretty":
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w.out, indented.String())
+		return err
+
+	case "yaml":
+		out, err := yaml.Marshal(jsonToYAML(gjson.ParseBytes(data)))
+		if err != nil {
+			return fmt.Errorf("encoding record to yaml: %s", err)
+		}
+		_, err = fmt.Fprint(w.out, "---\n"+string(out))
+		return err
+	}
+
+	parsed := gjson.ParseBytes(data)
+	if w.count == 0 {
+		w.columns = w.recordColumns(parsed)
+		headers := make([]string, len(w.columns))
+		for i, column := range w.columns {
+			headers[i] = column.header
+			if w.table != nil {
+				headers[i] = strings.ToUpper(headers[i])
+			}
+		}
+		if err := w.writeRow(headers); err != nil {
+			return err
+		}
+	}
+
+	row := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		row[i] = w.cell(column, parsed.Get(column.path))
+	}
+	return w.writeRow(row)
+}
+
+// recordColumns adds the other fields of `first` to the spec's columns,
+// when asked to.
+func (w *recordWriter) recordColumns(first gjson.Result) []outputColumn {
+	if !w.spec.otherFields {
+		return w.spec.columns
+	}
+
+	columns := append([]outputColumn(nil), w.spec.columns...)
+	known := map[string]bool{}
+	for _, column := range columns {
+		known[column.path] = true
+	}
+
+	first.ForEach(func(key, _ gjson.Result) bool {
+		if !known[key.String()] {
+			columns = append(columns, outputColumn{header: key.String(), path: gjsonEscape(key.String())})
+		}
+		return true
+	})
+	return columns
+}
+
+func (w *recordWriter) cell(column outputColumn, value gjson.Result) string {
+	var out string
+	switch {
+	case w.table != nil && column.format != nil:
+		return column.format(value)
+	case !value.Exists():
+		return column.missing
+	case value.Type == gjson.String:
+		out = value.String()
+	default:
+		out = value.Raw
+	}
+
+	if w.table != nil {
+		out = strings.NewReplacer("\t", " ", "\n", " ").Replace(out)
+		if !column.whole {
+			out = cutTableCell(out)
+		}
+	}
+	return out
+}
+
+// cutTableCell cuts `cell` to `tableCellMaxWidth` characters, on a rune
+// boundary so multi-byte characters are kept whole.
+func cutTableCell(cell string) string {
+	if utf8.RuneCountInString(cell) <= tableCellMaxWidth {
+		return cell
+	}
+
+	runes := 0
+	for i := range cell {
+		if runes == tableCellMaxWidth-3 {
+			return cell[:i] + "..."
+		}
+		runes++
+	}
+	return cell
+}
+
+func (w *recordWriter) writeRow(row []string) error {
+	if w.csv != nil {
+		return w.csv.Write(row)
+	}
+	_, err := fmt.Fprintln(w.table, strings.Join(row, "\t")+"\t")
+	return err
+}
+
+// close flushes buffered formats, it must be called once all records are written
+func (w *recordWriter) close() error {
+	switch {
+	case w.format == "json":
+		if w.count == 0 {
+			_, err := fmt.Fprintln(w.out, "[]")
+			return err
+		}
+		_, err := fmt.Fprintln(w.out, "\n]")
+		return err
+	case w.csv != nil:
+		w.csv.Flush()
+		return w.csv.Error()
+	case w.table != nil:
+		return w.table.Flush()
+	}
+	return nil
+}
+
+var gjsonPathEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`)
+
+// gjsonEscape makes a field name usable as a gjson path
+func gjsonEscape(field string) string {
+	return gjsonPathEscaper.Replace(field)
+}
+
+// jsonToYAML keeps the fields order of the JSON, where decoding to maps
+// would sort them, and integers as such instead of floats.
+func jsonToYAML(value gjson.Result) interface{} {
+	switch {
+	case value.IsObject():
+		var out yaml.MapSlice
+		value.ForEach(func(key, elem gjson.Result) bool {
+			out = append(out, yaml.MapItem{Key: key.String(), Value: jsonToYAML(elem)})
+			return true
+		})
+		return out
+
+	case value.IsArray():
+		out := []interface{}{}
+		value.ForEach(func(_, elem gjson.Result) bool {
+			out = append(out, jsonToYAML(elem))
+			return true
+		})
+		return out
+
+	case value.Type == gjson.Number:
+		if i, err := strconv.ParseInt(value.Raw, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(value.Raw, 10, 64); err == nil {
+			return u
+		}
+		return value.Float()
+	}
+
+	return value.Value()
+}
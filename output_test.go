@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCutTableCell(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		expected string
+	}{
+		{"short", "abc", "abc"},
+		{"max width", strings.Repeat("a", tableCellMaxWidth), strings.Repeat("a", tableCellMaxWidth)},
+		{"ascii cut", strings.Repeat("a", tableCellMaxWidth+1), strings.Repeat("a", tableCellMaxWidth-3) + "..."},
+		{"multi-byte at max width", strings.Repeat("é", tableCellMaxWidth), strings.Repeat("é", tableCellMaxWidth)},
+		{"multi-byte cut", strings.Repeat("é", tableCellMaxWidth+1), strings.Repeat("é", tableCellMaxWidth-3) + "..."},
+		{"mixed cut", "a" + strings.Repeat("日本", tableCellMaxWidth), "a" + strings.Repeat("日本", (tableCellMaxWidth-4)/2) + "..."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := cutTableCell(test.in); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
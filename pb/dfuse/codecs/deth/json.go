@@ -1,6 +1,7 @@
 package deth
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/big"
 
@@ -12,3 +13,22 @@ func (m *BigInt) MarshalJSONPB(*jsonpb.Marshaler) ([]byte, error) {
 	z.SetBytes(m.Bytes)
 	return []byte(fmt.Sprintf(`"%s"`, z.String())), nil
 }
+
+// UnmarshalJSONPB reads back the base 10 string of MarshalJSONPB
+func (m *BigInt) UnmarshalJSONPB(_ *jsonpb.Unmarshaler, data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("big int: %s", err)
+	}
+
+	z, ok := new(big.Int).SetString(value, 10)
+	if !ok || z.Sign() < 0 {
+		return fmt.Errorf("big int: invalid value %q", value)
+	}
+	m.Bytes = z.Bytes()
+	return nil
+}
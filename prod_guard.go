@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// dbTarget is a database a command is about to access, a big table
+// instance or a kv store.
+type dbTarget struct {
+	kind string
+	name string
+	prod bool
+}
+
+func (t dbTarget) String() string {
+	env := "non-prod"
+	if t.prod {
+		env = "PRODUCTION"
+	}
+	return fmt.Sprintf("%s %s (%s)", t.kind, t.name, env)
+}
+
+// bigtableTarget classifies an instance: a matching profile's `prod` value
+// wins, then anything reached through the emulator is non-prod, and
+// otherwise only `isTestEnv` instances are.
+func bigtableTarget(project, instance string) dbTarget {
+	target := dbTarget{kind: "bigtable", name: project + ":" + instance}
+	profiles := matchingProfiles(func(p *dohProfile) bool { return p.Bigtable == target.name })
+	for _, profile := range profiles {
+		if profile.Prod != nil {
+			target.prod = *profile.Prod
+			return target
+		}
+	}
+	for _, profile := range profiles {
+		if profile.Emulator {
+			return target
+		}
+	}
+
+	target.prod = os.Getenv(emulatorHostDefault) == "" && !isTestEnv(project, instance)
+	return target
+}
+
+// kvTarget classifies a kv store DSN: a matching profile's `prod` value
+// wins, local badger stores are non-prod, bigkv ones follow their instance
+// and anything else is considered prod.
+func kvTarget(dsn string) dbTarget {
+	target := dbTarget{kind: "kv store", name: dsn}
+	for _, profile := range matchingProfiles(func(p *dohProfile) bool { return p.KV == dsn }) {
+		if profile.Prod != nil {
+			target.prod = *profile.Prod
+			return target
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(dsn, "badger://"):
+		target.prod = false
+	case strings.HasPrefix(dsn, "bigkv://"):
+		// bigkv://project.instance/table
+		host := strings.SplitN(strings.TrimPrefix(dsn, "bigkv://"), "/", 2)[0]
+		parts := strings.SplitN(host, ".", 2)
+		if len(parts) != 2 {
+			target.prod = true
+			break
+		}
+		target.prod = bigtableTarget(parts[0], parts[1]).prod
+	default:
+		target.prod = true
+	}
+	return target
+}
+
+// matchingProfiles returns the config profiles `matches` accepts, by name
+func matchingProfiles(matches func(*dohProfile) bool) (out []*dohProfile) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil
+	}
+
+	for _, name := range config.profileNames() {
+		if profile := config.profiles[name]; matches(profile) {
+			out = append(out, profile)
+		}
+	}
+	return out
+}
+
+var announcedTargets = map[string]bool{}
+
+// announceTarget prints the resolved target once, on stderr to keep outputs
+// parseable, and records production accesses in the audit log.
+func announceTarget(target dbTarget) {
+	if announcedTargets[target.name] {
+		return
+	}
+	announcedTargets[target.name] = true
+
+	fmt.Fprintf(os.Stderr, "Using %s\n", target)
+	if target.prod {
+		auditProdAccess(target, "access", "")
+	}
+}
+
+// confirmProd lets `operation` (a full scan, any write) through on non-prod
+// targets. On prod, it needs `--yes-prod` or the user typing "yes" on the
+// terminal, stdin being left alone as some commands read their input there.
+func confirmProd(target dbTarget, operation string) error {
+	if !target.prod {
+		return nil
+	}
+
+	if viper.GetBool("global-yes-prod") {
+		auditProdAccess(target, operation, "--yes-prod")
+		return nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		auditProdAccess(target, operation, "refused")
+		return fmt.Errorf("refusing to %s on %s without confirmation, pass --yes-prod if you really mean it", operation, target)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "About to %s on %s.\nType 'yes' to continue: ", operation, target)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		auditProdAccess(target, operation, "refused")
+		return fmt.Errorf("aborted, not confirmed")
+	}
+
+	auditProdAccess(target, operation, "confirmed")
+	return nil
+}
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Target    string    `json:"target"`
+	Operation string    `json:"operation"`
+	Outcome   string    `json:"outcome,omitempty"`
+	Command   []string  `json:"command"`
+}
+
+func auditLogPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "audit.log")
+}
+
+// auditProdAccess appends a JSON line to the audit log. Failing to write
+// it only warns, not to lock people out of a read-only home.
+func auditProdAccess(target dbTarget, operation, outcome string) {
+	entry := auditEntry{
+		Time:      time.Now().UTC(),
+		User:      currentUser(),
+		Target:    target.kind + " " + target.name,
+		Operation: operation,
+		Outcome:   outcome,
+		Command:   os.Args,
+	}
+
+	if err := appendAuditEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: writing audit log: %s\n", err)
+	}
+}
+
+func currentUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+func appendAuditEntry(entry auditEntry) error {
+	path := auditLogPath()
+	if path == "" {
+		return fmt.Errorf("no home directory")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// btCheckDestructiveAllowed asks for confirmation before writing to a
+// production instance.
+func btCheckDestructiveAllowed(project, instance, operation string) error {
+	return confirmProd(bigtableTarget(project, instance), operation)
+}
+
+// btConfirmScan asks for confirmation before reading a whole production
+// table, that is without any key selection nor `--limit`.
+func btConfirmScan(project, instance, cmdKey, table string) error {
+	if !btRowSelectionIsUnbounded(cmdKey) || viper.GetInt(cmdKey+"-limit") != 0 {
+		return nil
+	}
+	return confirmProd(bigtableTarget(project, instance), fmt.Sprintf("scan the whole %s table", table))
+}
+
+// kvCheckWriteAllowed asks for confirmation before writing to a production
+// kv store.
+func kvCheckWriteAllowed(operation string) error {
+	dsn, err := kvStoreDSN()
+	if err != nil {
+		return err
+	}
+	return confirmProd(kvTarget(dsn), operation)
+}
+
+// kvConfirmScan asks for confirmation before reading every key of a
+// production kv store.
+func kvConfirmScan(prefix []byte) error {
+	if len(prefix) != 0 {
+		return nil
+	}
+
+	dsn, err := kvStoreDSN()
+	if err != nil {
+		return err
+	}
+	return confirmProd(kvTarget(dsn), "scan every key")
+}
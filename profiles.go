@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const profilesHelp = `Profiles are read from ~/.config/doh/config.yaml (or --config):
+
+    default_profile: dev
+    profiles:
+      dev:
+        bigtable: dev:dev               # project:instance
+        emulator: true                  # use BIGTABLE_EMULATOR_HOST, localhost:8086 unless emulator_host is set
+        kv: badger:///tmp/kvdb.db
+        protocol: EOS
+        stores:
+          merged_blocks: file:///tmp/merged-blocks
+      eos-mainnet:
+        bigtable: dfuseio-global:dfuse-saas
+        kv: bigkv://dfuseio-global.dfuse-saas/eos-mainnet-v1
+        protocol: EOS
+        prod: true                      # defaults to false for dev/emulator instances and badger stores
+
+Select one with --profile on any command, or use its name as --db. Profile
+names and store names are case insensitive. The "prod" and "dev" profiles
+are always defined, unless overridden by the config file.`
+
+var profilesCmd = &cobra.Command{Use: "profiles", Short: "list the database profiles of the config file", Long: profilesHelp, RunE: listProfiles, Args: cobra.NoArgs}
+
+// dohProfile groups everything needed to talk to one environment
+type dohProfile struct {
+	Name         string            `mapstructure:"-"`
+	Bigtable     string            `mapstructure:"bigtable"`
+	Emulator     bool              `mapstructure:"emulator"`
+	EmulatorHost string            `mapstructure:"emulator_host"`
+	KV           string            `mapstructure:"kv"`
+	Stores       map[string]string `mapstructure:"stores"`
+	Protocol     string            `mapstructure:"protocol"`
+	Prod         *bool             `mapstructure:"prod"`
+
+	builtin bool
+}
+
+var builtinProfiles = []*dohProfile{
+	{Name: "prod", Bigtable: "dfuseio-global:dfuse-saas", builtin: true},
+	{Name: "dev", Bigtable: "dev:dev", Emulator: true, builtin: true},
+}
+
+type dohConfig struct {
+	path           string
+	defaultProfile string
+	profiles       map[string]*dohProfile
+}
+
+var loadedConfig *dohConfig
+
+func init() {
+	rootCmd.AddCommand(profilesCmd)
+
+	rootCmd.PersistentFlags().String("profile", "", "database profile to use (see 'doh profiles'), defaults to the config file's default_profile")
+	rootCmd.PersistentFlags().String("config", "", "config file, defaults to ~/.config/doh/config.yaml")
+	rootCmd.PersistentFlags().Bool("yes-prod", false, "don't ask for confirmation before full scans or writes on production databases")
+}
+
+// configDir is ~/.config/doh, honoring XDG_CONFIG_HOME
+func configDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "doh")
+}
+
+func configFilePath() string {
+	if path := viper.GetString("global-config"); path != "" {
+		return path
+	}
+
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// loadConfig reads the config file once, a missing file only leaving the
+// builtin profiles.
+func loadConfig() (*dohConfig, error) {
+	if loadedConfig != nil {
+		return loadedConfig, nil
+	}
+
+	config := &dohConfig{path: configFilePath(), profiles: map[string]*dohProfile{}}
+	for _, profile := range builtinProfiles {
+		config.profiles[profile.Name] = profile
+	}
+
+	if _, err := os.Stat(config.path); err == nil {
+		// Its own viper instance, so the config doesn't mix with flag values
+		v := viper.New()
+		v.SetConfigFile(config.path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("reading config %q: %s", config.path, err)
+		}
+
+		var profiles map[string]*dohProfile
+		if err := v.UnmarshalKey("profiles", &profiles); err != nil {
+			return nil, fmt.Errorf("reading config %q profiles: %s", config.path, err)
+		}
+
+		for name, profile := range profiles {
+			if profile == nil {
+				profile = &dohProfile{}
+			}
+			profile.Name = name
+			config.profiles[name] = profile
+		}
+		config.defaultProfile = strings.ToLower(v.GetString("default_profile"))
+	} else if viper.GetString("global-config") != "" {
+		return nil, fmt.Errorf("reading config: %s", err)
+	}
+
+	if config.defaultProfile != "" && config.profiles[config.defaultProfile] == nil {
+		return nil, fmt.Errorf("config %q: default_profile %q is not defined", config.path, config.defaultProfile)
+	}
+
+	loadedConfig = config
+	return config, nil
+}
+
+// activeProfile is the `--profile` one, or the config's default, or nil
+func activeProfile() (*dohProfile, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToLower(viper.GetString("global-profile"))
+	if name == "" {
+		name = config.defaultProfile
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	profile := config.profiles[name]
+	if profile == nil {
+		return nil, fmt.Errorf("unknown --profile %q, known profiles: %s", name, strings.Join(config.profileNames(), ", "))
+	}
+	return profile, nil
+}
+
+func (c *dohConfig) profileNames() (out []string) {
+	for name := range c.profiles {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return
+}
+
+// resolveDb turns a `--db` value into a bigtable project and instance. It
+// is either `project:instance` or a profile name, falling back to the active
+// profile when empty. The resolved instance is announced on stderr.
+func resolveDb(db string) (project, instance string, err error) {
+	project, instance, err = resolveProfileDb(db)
+	if err != nil {
+		return "", "", err
+	}
+
+	announceTarget(bigtableTarget(project, instance))
+	return project, instance, nil
+}
+
+func resolveProfileDb(db string) (project, instance string, err error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", "", err
+	}
+
+	profile := config.profiles[strings.ToLower(db)]
+	if db == "" {
+		profile, err = activeProfile()
+		if err != nil {
+			return "", "", err
+		}
+		if profile == nil {
+			return "", "", fmt.Errorf("no bigtable database selected, use --db project:instance or --profile (see 'doh profiles')")
+		}
+	}
+
+	if profile == nil {
+		return parseDb(db)
+	}
+
+	if profile.Bigtable == "" {
+		return "", "", fmt.Errorf("profile %q has no bigtable database", profile.Name)
+	}
+	if profile.Emulator && os.Getenv(emulatorHostDefault) == "" {
+		host := profile.EmulatorHost
+		if host == "" {
+			host = emulatorDefaultHostValue
+		}
+		os.Setenv(emulatorHostDefault, host)
+	}
+	return parseDb(profile.Bigtable)
+}
+
+// profileKVStore is the active profile's kv DSN, empty without one
+func profileKVStore() (string, error) {
+	profile, err := activeProfile()
+	if err != nil || profile == nil {
+		return "", err
+	}
+	return profile.KV, nil
+}
+
+// protocolOrDefault returns `flagValue`, or the active profile's protocol
+// when it's empty.
+func protocolOrDefault(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	profile, err := activeProfile()
+	if err != nil || profile == nil {
+		return ""
+	}
+	return profile.Protocol
+}
+
+// profileStoreURL returns the active profile's store URL named `name`
+func profileStoreURL(name string) (string, error) {
+	profile, err := activeProfile()
+	if err != nil {
+		return "", err
+	}
+	if profile == nil {
+		return "", nil
+	}
+	return profile.Stores[strings.ToLower(name)], nil
+}
+
+func listProfiles(cmd *cobra.Command, args []string) (err error) {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	active, err := activeProfile()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(config.path); err == nil {
+		fmt.Printf("Profiles from %s:\n", config.path)
+	} else {
+		fmt.Printf("No config file at %s, builtin profiles:\n", config.path)
+	}
+	fmt.Println("")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tNAME\tBIGTABLE\tKV\tPROTOCOL\tSTORES\t")
+	for _, name := range config.profileNames() {
+		profile := config.profiles[name]
+
+		marker := ""
+		if profile == active {
+			marker = "*"
+		}
+
+		bigtable := profile.Bigtable
+		if profile.Emulator {
+			bigtable += " (emulator)"
+		}
+		if profile.Bigtable != "" {
+			project, instance, _ := parseDb(profile.Bigtable)
+			if bigtableTarget(project, instance).prod {
+				bigtable += " (prod)"
+			}
+		}
+		if profile.builtin {
+			name += " (builtin)"
+		}
+
+		var stores []string
+		for storeName, url := range profile.Stores {
+			stores = append(stores, storeName+"="+url)
+		}
+		sort.Strings(stores)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", marker, name, bigtable, profile.KV, profile.Protocol, strings.Join(stores, " "))
+	}
+	return w.Flush()
+}
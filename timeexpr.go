@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	"github.com/spf13/viper"
+)
+
+const timeExprHelp = "ms since EPOCH, RFC3339, a UTC date like '2020-04-01' or '2020-04-01 12:30', relative to now like '-2h', '-3d' or 'now-15m', or 'block:NUM' / 'block:ID' (EOS block IDs only)"
+
+// Dates without a zone are taken as UTC, like block times
+var zonelessTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var daysDurationRegex = regexp.MustCompile(`^([+-]?)(\d+)d(.*)$`)
+
+// timeExpr is a parsed `--ts-start` / `--ts-end` value, either a point in
+// time or a block reference, which each command resolves against its own
+// data (block numbers of a dbin file, blocks table of big table, ...).
+type timeExpr struct {
+	time     time.Time
+	blockNum uint64
+	blockID  string // set for `block:ID`, until resolved to `blockNum`
+	isBlock  bool
+}
+
+func (e *timeExpr) String() string {
+	if e.blockID != "" {
+		return "block " + e.blockID
+	}
+	if e.isBlock {
+		return fmt.Sprintf("block %d", e.blockNum)
+	}
+	return e.time.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTimeExpr parses any of the `timeExprHelp` forms, relative ones
+// being based on `now`. It returns nil for an empty value.
+func parseTimeExpr(in string, now time.Time) (*timeExpr, error) {
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(in, "block:") {
+		blockNum, blockID, err := parseBlockRef(strings.TrimPrefix(in, "block:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid block reference %q: %s", in, err)
+		}
+		return &timeExpr{blockNum: blockNum, blockID: blockID, isBlock: true}, nil
+	}
+
+	if ms, err := strconv.ParseInt(in, 10, 64); err == nil {
+		return &timeExpr{time: time.Unix(0, ms*int64(time.Millisecond))}, nil
+	}
+
+	if relative := strings.TrimPrefix(in, "now"); relative == "" || strings.HasPrefix(relative, "-") || strings.HasPrefix(relative, "+") {
+		if relative == "" {
+			return &timeExpr{time: now}, nil
+		}
+
+		duration, err := parseDurationWithDays(relative)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relative time %q: %s", in, err)
+		}
+		return &timeExpr{time: now.Add(duration)}, nil
+	}
+
+	if tm, err := time.Parse(time.RFC3339Nano, in); err == nil {
+		return &timeExpr{time: tm}, nil
+	}
+
+	for _, layout := range zonelessTimeLayouts {
+		if tm, err := time.ParseInLocation(layout, in, time.UTC); err == nil {
+			return &timeExpr{time: tm}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot parse time %q, expected %s", in, timeExprHelp)
+}
+
+// parseDurationWithDays is `time.ParseDuration`, also accepting a leading
+// number of days (ex: `-3d`, `1d12h`).
+func parseDurationWithDays(in string) (time.Duration, error) {
+	match := daysDurationRegex.FindStringSubmatch(in)
+	if match == nil {
+		return time.ParseDuration(in)
+	}
+
+	days, _ := strconv.ParseInt(match[2], 10, 64)
+	duration := time.Duration(days) * 24 * time.Hour
+	if match[3] != "" {
+		rest, err := time.ParseDuration(match[3])
+		if err != nil {
+			return 0, err
+		}
+		duration += rest
+	}
+
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// parseBlockRef takes a block number, or a 64 hex characters block ID
+// which is returned as is, only the protocol telling its number.
+func parseBlockRef(ref string) (blockNum uint64, blockID string, err error) {
+	if blockNum, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		return blockNum, "", nil
+	}
+
+	if _, err := hex.DecodeString(ref); err == nil && len(ref) == 64 {
+		return 0, strings.ToLower(ref), nil
+	}
+	return 0, "", fmt.Errorf("expected a block number or a 64 hex characters block ID")
+}
+
+// eosBlockIDNum returns the number of an EOS block ID, its first 8 hex
+// characters. Other protocols' IDs are hashes, without the number.
+func eosBlockIDNum(blockID string) (uint64, error) {
+	if len(blockID) < 8 {
+		return 0, fmt.Errorf("invalid EOS block ID %q", blockID)
+	}
+
+	blockNum, err := strconv.ParseUint(blockID[:8], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid EOS block ID %q: %s", blockID, err)
+	}
+	return blockNum, nil
+}
+
+// resolveBlockID sets the block number of a `block:ID` reference to
+// `protocol` blocks, which only works with EOS ones.
+func (e *timeExpr) resolveBlockID(protocol pbbstream.Protocol) (err error) {
+	if e == nil || e.blockID == "" {
+		return nil
+	}
+	if protocol != pbbstream.Protocol_EOS {
+		return fmt.Errorf("%s block IDs don't hold their block number, use block:NUM", protocol)
+	}
+
+	e.blockNum, err = eosBlockIDNum(e.blockID)
+	return err
+}
+
+// blockTimeResolver returns the time of a block reference
+type blockTimeResolver func(ref *timeExpr) (time.Time, error)
+
+// resolveTime returns the time of `e`, block references going through
+// `resolveBlock`, which is nil where they're not supported.
+func (e *timeExpr) resolveTime(resolveBlock blockTimeResolver) (time.Time, error) {
+	if !e.isBlock {
+		return e.time, nil
+	}
+	if resolveBlock == nil {
+		return time.Time{}, fmt.Errorf("block references are not supported here")
+	}
+	return resolveBlock(e)
+}
+
+// btTimestampFlag parses the `cmdKey` time `flag`, block references being
+// looked up in the blocks table next to `table`. Zero means unset.
+func btTimestampFlag(cmdKey, flag, table string) (bigtable.Timestamp, error) {
+	expr, err := parseTimeExpr(viper.GetString(cmdKey+"-"+flag), time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("--%s: %s", flag, err)
+	}
+	if expr == nil {
+		return 0, nil
+	}
+
+	tm, err := expr.resolveTime(btBlockTimeResolver(cmdKey, table))
+	if err != nil {
+		return 0, fmt.Errorf("--%s: %s", flag, err)
+	}
+	return bigtable.Time(tm), nil
+}
+
+// btCmdDb is the `--db` value of a command, `bt` ones having a global one
+func btCmdDb(cmdKey string) string {
+	if strings.HasPrefix(cmdKey, "bt-") {
+		return viper.GetString("bt-global-db")
+	}
+	return viper.GetString(cmdKey + "-db")
+}
+
+// btSiblingTable swaps the suffix of a dfuse table name, like
+// `eos-mainnet-v1-trxs` to `eos-mainnet-v1-blocks`.
+func btSiblingTable(table, suffix string) (string, error) {
+	idx := strings.LastIndex(table, "-")
+	if idx == -1 {
+		return "", fmt.Errorf("cannot guess the %s table next to %q, expected a name like eos-mainnet-v1-trxs", suffix, table)
+	}
+	return table[:idx+1] + suffix, nil
+}
+
+func btBlockTimeResolver(cmdKey, table string) blockTimeResolver {
+	return func(ref *timeExpr) (time.Time, error) {
+		blocksTable, err := btSiblingTable(table, "blocks")
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		protocol, err := btTableProtocol(cmdKey, table)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if err := ref.resolveBlockID(protocol); err != nil {
+			return time.Time{}, err
+		}
+
+		project, instance, err := resolveDb(btCmdDb(cmdKey))
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		client, err := newBigTableClient(project, instance)
+		if err != nil {
+			return time.Time{}, err
+		}
+		defer client.Close()
+
+		return btBlockTime(context.Background(), client.Open(blocksTable), protocol, ref.blockNum, ref.blockID)
+	}
+}
+
+// btTableProtocol is the `--protocol` of `cmdKey` (or the profile's), else
+// the one a dfuse table name starts with, like ETH for eth-mainnet-v1-trxs.
+func btTableProtocol(cmdKey, table string) (pbbstream.Protocol, error) {
+	name := protocolOrDefault(viper.GetString(cmdKey + "-protocol"))
+	if name == "" {
+		name = strings.ToUpper(strings.SplitN(table, "-", 2)[0])
+	}
+
+	protocol := pbbstream.Protocol(pbbstream.Protocol_value[name])
+	if protocol == pbbstream.Protocol_UNKNOWN {
+		return protocol, fmt.Errorf("cannot tell the protocol of %q blocks, from --protocol, the profile or the table name", table)
+	}
+	return protocol, nil
+}
+
+// btBlockTime returns the header time of the first row of `blockNum` in a
+// blocks table, the one of `blockID` when set.
+func btBlockTime(ctx context.Context, table *bigtable.Table, protocol pbbstream.Protocol, blockNum uint64, blockID string) (time.Time, error) {
+	refs, err := btBlocksByNum(ctx, table, protocol, blockNum)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, ref := range refs {
+		if blockID == "" || ref.ID == blockID {
+			return ref.Timestamp, nil
+		}
+	}
+
+	if blockID != "" {
+		return time.Time{}, fmt.Errorf("block %s not found", blockID)
+	}
+	return time.Time{}, fmt.Errorf("block %d not found", blockNum)
+}
+
+// btTimelineBlockNum returns the number of the first block at or after
+// `tm`, out of an EOS timeline table whose forward keys hold block times in
+// milliseconds.
+func btTimelineBlockNum(ctx context.Context, table *bigtable.Table, tm time.Time) (uint64, error) {
+	layout, err := parseBTKeyLayout(btKeyLayouts[pbbstream.Protocol_EOS]["timeline"][0])
+	if err != nil {
+		return 0, err
+	}
+
+	start := fmt.Sprintf("bf:%016x", tm.UnixNano()/int64(time.Millisecond))
+
+	var blockID string
+	err = table.ReadRows(ctx, bigtable.NewRange(start, "bf;"), func(row bigtable.Row) bool {
+		if parsed := layout.parse(row.Key()); parsed != nil {
+			blockID, _ = parsed["block_id"].(string)
+		}
+		return false
+	}, bigtable.RowFilter(bigtable.StripValueFilter()), bigtable.LimitRows(1))
+	if err != nil {
+		return 0, fmt.Errorf("reading timeline: %s", err)
+	}
+	if blockID == "" {
+		return 0, fmt.Errorf("no block at or after %s in the timeline", tm.UTC().Format(time.RFC3339))
+	}
+
+	return eosBlockIDNum(blockID)
+}
+
+// blockRangeFilter keeps blocks from `--ts-start` (inclusive) to `--ts-end`
+// (exclusive), block references comparing with the block number and times
+// with the block time.
+type blockRangeFilter struct {
+	start *timeExpr
+	end   *timeExpr
+}
+
+// newBlockRangeFilter parses the `cmdKey` bounds, `block:ID` ones being
+// resolved as IDs of `protocol` blocks.
+func newBlockRangeFilter(cmdKey string, protocol pbbstream.Protocol) (*blockRangeFilter, error) {
+	now := time.Now()
+	start, err := parseTimeExpr(viper.GetString(cmdKey+"-ts-start"), now)
+	if err != nil {
+		return nil, fmt.Errorf("--ts-start: %s", err)
+	}
+	end, err := parseTimeExpr(viper.GetString(cmdKey+"-ts-end"), now)
+	if err != nil {
+		return nil, fmt.Errorf("--ts-end: %s", err)
+	}
+
+	if err := start.resolveBlockID(protocol); err != nil {
+		return nil, fmt.Errorf("--ts-start: %s", err)
+	}
+	if err := end.resolveBlockID(protocol); err != nil {
+		return nil, fmt.Errorf("--ts-end: %s", err)
+	}
+	return &blockRangeFilter{start: start, end: end}, nil
+}
+
+func (f *blockRangeFilter) isEmpty() bool {
+	return f.start == nil && f.end == nil
+}
+
+// hasTimes is true when a bound is a time, which needs block times to compare
+func (f *blockRangeFilter) hasTimes() bool {
+	return (f.start != nil && !f.start.isBlock) || (f.end != nil && !f.end.isBlock)
+}
+
+// resolveTimes turns the time bounds into block references through
+// `blockAt`, returning the first block at or after a time.
+func (f *blockRangeFilter) resolveTimes(blockAt func(time.Time) (uint64, error)) error {
+	for _, bound := range []*timeExpr{f.start, f.end} {
+		if bound == nil || bound.isBlock {
+			continue
+		}
+
+		blockNum, err := blockAt(bound.time)
+		if err != nil {
+			return fmt.Errorf("resolving %s to a block: %s", bound, err)
+		}
+		bound.blockNum, bound.isBlock = blockNum, true
+	}
+	return nil
+}
+
+func (f *blockRangeFilter) keep(blockNum uint64, blockTime time.Time) bool {
+	if f.start != nil {
+		if (f.start.isBlock && blockNum < f.start.blockNum) || (!f.start.isBlock && blockTime.Before(f.start.time)) {
+			return false
+		}
+	}
+	if f.end != nil {
+		if (f.end.isBlock && blockNum >= f.end.blockNum) || (!f.end.isBlock && !blockTime.Before(f.end.time)) {
+			return false
+		}
+	}
+	return true
+}
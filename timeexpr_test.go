@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+)
+
+func TestParseTimeExpr(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 30, 0, 0, time.UTC)
+	blockID := "0000303900000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		in          string
+		expected    string // a time as RFC3339Nano, `block N` or `<nil>`
+		expectedErr bool
+	}{
+		{"", "<nil>", false},
+		{"   ", "<nil>", false},
+
+		{"1585744200000", "2020-04-01T12:30:00Z", false},
+		{"1585744200123", "2020-04-01T12:30:00.123Z", false},
+		{"0", "1970-01-01T00:00:00Z", false},
+
+		{"now", "2020-04-01T12:30:00Z", false},
+		{"-2h", "2020-04-01T10:30:00Z", false},
+		{"now-15m", "2020-04-01T12:15:00Z", false},
+		{"now+1h30m", "2020-04-01T14:00:00Z", false},
+		{"-3d", "2020-03-29T12:30:00Z", false},
+		{"now-1d12h", "2020-03-31T00:30:00Z", false},
+		{"+1d", "2020-04-02T12:30:00Z", false},
+
+		{"2020-04-01T14:32:00Z", "2020-04-01T14:32:00Z", false},
+		{"2020-04-01T14:32:00.5+02:00", "2020-04-01T12:32:00.5Z", false},
+		{"2020-04-01", "2020-04-01T00:00:00Z", false},
+		{"2020-04-01 14:32", "2020-04-01T14:32:00Z", false},
+		{"2020-04-01T14:32", "2020-04-01T14:32:00Z", false},
+		{"2020-04-01 14:32:05.25", "2020-04-01T14:32:05.25Z", false},
+
+		{"block:12345", "block 12345", false},
+		{"block:0", "block 0", false},
+		{"block:" + blockID, "block " + blockID, false},
+		{"block:ABCD" + blockID[4:], "block abcd" + blockID[4:], false},
+
+		{"yesterday", "", true},
+		{"now-2x", "", true},
+		{"-1dx", "", true},
+		{"2020-13-01", "", true},
+		{"2020-04-01 25:00", "", true},
+		{"block:", "", true},
+		{"block:abc", "", true},
+		{"block:-1", "", true},
+		{"block:" + blockID[:63], "", true},
+		{"block:zzzz" + blockID[4:], "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			expr, err := parseTimeExpr(test.in, now)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", expr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			actual := "<nil>"
+			if expr != nil {
+				actual = expr.String()
+			}
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseDurationWithDays(t *testing.T) {
+	tests := []struct {
+		in          string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{"2h", 2 * time.Hour, false},
+		{"-15m", -15 * time.Minute, false},
+		{"3d", 72 * time.Hour, false},
+		{"-3d", -72 * time.Hour, false},
+		{"+1d", 24 * time.Hour, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"-1d12h30m", -(36*time.Hour + 30*time.Minute), false},
+		{"0d", 0, false},
+		{"d", 0, true},
+		{"1d2", 0, true},
+		{"1w", 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			actual, err := parseDurationWithDays(test.in)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTimeExprResolveTime(t *testing.T) {
+	blockTime := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	resolver := func(ref *timeExpr) (time.Time, error) {
+		if ref.blockNum != 12345 {
+			return time.Time{}, fmt.Errorf("block %d not found", ref.blockNum)
+		}
+		return blockTime, nil
+	}
+
+	expr := &timeExpr{blockNum: 12345, isBlock: true}
+	if actual, err := expr.resolveTime(resolver); err != nil || !actual.Equal(blockTime) {
+		t.Errorf("expected %s, got %s (%v)", blockTime, actual, err)
+	}
+
+	if _, err := (&timeExpr{blockNum: 1, isBlock: true}).resolveTime(resolver); err == nil {
+		t.Errorf("expected an error for an unknown block")
+	}
+	if _, err := expr.resolveTime(nil); err == nil {
+		t.Errorf("expected an error without a block resolver")
+	}
+
+	tm := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	if actual, err := (&timeExpr{time: tm}).resolveTime(nil); err != nil || !actual.Equal(tm) {
+		t.Errorf("expected %s, got %s (%v)", tm, actual, err)
+	}
+}
+
+func TestTimeExprResolveBlockID(t *testing.T) {
+	blockID := "0000303900000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		protocol    pbbstream.Protocol
+		expected    uint64
+		expectedErr bool
+	}{
+		{pbbstream.Protocol_EOS, 12345, false},
+		{pbbstream.Protocol_ETH, 0, true},
+		{pbbstream.Protocol_UNKNOWN, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.protocol.String(), func(t *testing.T) {
+			expr := &timeExpr{blockID: blockID, isBlock: true}
+			err := expr.resolveBlockID(test.protocol)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatalf("expected an error, got block %d", expr.blockNum)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if expr.blockNum != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, expr.blockNum)
+			}
+		})
+	}
+
+	var unset *timeExpr
+	if err := unset.resolveBlockID(pbbstream.Protocol_ETH); err != nil {
+		t.Errorf("unexpected error for an unset bound: %s", err)
+	}
+	if err := (&timeExpr{blockNum: 12, isBlock: true}).resolveBlockID(pbbstream.Protocol_ETH); err != nil {
+		t.Errorf("unexpected error for a block number: %s", err)
+	}
+}
+
+func TestBlockRangeFilter(t *testing.T) {
+	base := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	filter := &blockRangeFilter{
+		start: &timeExpr{blockNum: 10, isBlock: true},
+		end:   &timeExpr{time: base.Add(time.Minute)},
+	}
+
+	tests := []struct {
+		blockNum uint64
+		offset   time.Duration
+		expected bool
+	}{
+		{9, 0, false},
+		{10, 0, true},
+		{11, 59 * time.Second, true},
+		{12, time.Minute, false},
+	}
+
+	for _, test := range tests {
+		if actual := filter.keep(test.blockNum, base.Add(test.offset)); actual != test.expected {
+			t.Errorf("block %d at +%s: expected %t, got %t", test.blockNum, test.offset, test.expected, actual)
+		}
+	}
+
+	err := filter.resolveTimes(func(tm time.Time) (uint64, error) { return 20, nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !filter.end.isBlock || filter.end.blockNum != 20 {
+		t.Errorf("expected the end to resolve to block 20, got %s", filter.end)
+	}
+	if filter.keep(20, base) || !filter.keep(19, base.Add(time.Hour)) {
+		t.Errorf("expected blocks to compare by number once resolved")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	pbbstream "github.com/dfuse-io/doh/pb/dfuse/bstream/v1"
+	pbdeos "github.com/dfuse-io/doh/pb/dfuse/codecs/deos"
+	pbdeth "github.com/dfuse-io/doh/pb/dfuse/codecs/deth"
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const treeFlagHelp = "render transaction traces as trees, EOS actions nested under their creator with their DB, RAM and table ops, ETH calls under their parent with their state changes and a net balance changes summary: 'json' (default) for nested records, or 'text' for ASCII art"
+
+// addTreeFlag adds `--tree`, which takes `json` when given without a value
+func addTreeFlag(cmd *cobra.Command) {
+	cmd.Flags().String("tree", "", treeFlagHelp)
+	cmd.Flags().Lookup("tree").NoOptDefVal = "json"
+}
+
+// treeMode returns the `--tree` value of `cmdKey`, empty when not asked for
+func treeMode(cmdKey string) (string, error) {
+	switch mode := viper.GetString(cmdKey + "-tree"); mode {
+	case "", "json", "text":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid --tree %q, expected json or text", mode)
+	}
+}
+
+// Trace trees records differ by protocol, and so do their columns
+var traceTreesOutputSpecs = map[pbbstream.Protocol]outputSpec{
+	pbbstream.Protocol_EOS: eosTraceTreesOutputSpec,
+	pbbstream.Protocol_ETH: ethTraceTreesOutputSpec,
+}
+
+// newTreeRecordWriter is the records output of the `json` tree mode, nil
+// in `text` mode which writes directly.
+func newTreeRecordWriter(mode string, protocol pbbstream.Protocol) (*recordWriter, error) {
+	if mode == "text" {
+		return nil, nil
+	}
+
+	spec, ok := traceTreesOutputSpecs[protocol]
+	if !ok {
+		return nil, fmt.Errorf("--tree supports EOS and ETH traces, not %s", protocol)
+	}
+	return newRecordWriter(spec)
+}
+
+// closeTreeRecordWriter closes `output` when there's one
+func closeTreeRecordWriter(output *recordWriter) error {
+	if output == nil {
+		return nil
+	}
+	return output.close()
+}
+
+// traceTreesProtocol is the protocol of the traces `writeTraceTrees` renders
+// out of `msg`.
+func traceTreesProtocol(msg proto.Message) (pbbstream.Protocol, error) {
+	switch msg.(type) {
+	case *pbdeos.TransactionTrace, *pbdeos.Block:
+		return pbbstream.Protocol_EOS, nil
+	case *pbdeth.TransactionTrace, *pbdeth.Block:
+		return pbbstream.Protocol_ETH, nil
+	}
+	return pbbstream.Protocol_UNKNOWN, fmt.Errorf("--tree needs transaction traces or blocks, not %s", proto.MessageName(msg))
+}
+
+// traceTreeRecord is the `json` mode tree of `msg` when it's a transaction
+// trace, nil otherwise.
+func traceTreeRecord(msg proto.Message) (interface{}, error) {
+	switch m := msg.(type) {
+	case *pbdeos.TransactionTrace:
+		return newEOSTraceTree(m)
+	case *pbdeth.TransactionTrace:
+		return newETHTraceTree(m, 0), nil
+	}
+	return nil, nil
+}
+
+// writeTraceTrees renders the transaction traces held by `msg`, a trace or
+// a block.
+func writeTraceTrees(mode string, output *recordWriter, msg proto.Message) error {
+	switch m := msg.(type) {
+	case *pbdeos.TransactionTrace:
+		return writeEOSTraceTrees(mode, output, []*pbdeos.TransactionTrace{m})
+	case *pbdeos.Block:
+		return writeEOSTraceTrees(mode, output, m.TransactionTraces)
+	case *pbdeth.TransactionTrace:
+		return writeETHTraceTrees(mode, output, 0, []*pbdeth.TransactionTrace{m})
+	case *pbdeth.Block:
+		return writeETHTraceTrees(mode, output, m.Number, m.TransactionTraces)
+	}
+	_, err := traceTreesProtocol(msg)
+	return err
+}
+
+// writeTextTree draws `root` in ASCII art, children below their parent
+func writeTextTree(w io.Writer, root *blockTreeNode) {
+	fmt.Fprintln(w, root.label)
+
+	var walk func(node *blockTreeNode, prefix string)
+	walk = func(node *blockTreeNode, prefix string) {
+		for i, child := range node.children {
+			branch, indent := "|-- ", "|   "
+			if i == len(node.children)-1 {
+				branch, indent = "`-- ", "    "
+			}
+
+			lines := strings.Split(child.label, "\n")
+			fmt.Fprintln(w, prefix+branch+lines[0])
+			for _, line := range lines[1:] {
+				fmt.Fprintln(w, prefix+indent+line)
+			}
+			walk(child, prefix+indent)
+		}
+	}
+	walk(root, "")
+}